@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// assignHeadingIDs makes the id of every heading under doc unique within the
+// page. Explicit {#id} values, marked in explicit, are kept where possible and
+// repeats get a numeric suffix.
+func assignHeadingIDs(doc ast.Node, explicit map[*ast.Heading]bool) {
+	taken := make(map[string]bool)
+	var headings []*ast.Heading
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if h, ok := node.(*ast.Heading); ok && entering && h.HeadingID != "" {
+			headings = append(headings, h)
+		}
+		return ast.GoToNext
+	})
+
+	// Explicit ids claim their names before generated ones
+	for _, pass := range []bool{true, false} {
+		for _, h := range headings {
+			if explicit[h] == pass {
+				h.HeadingID = uniqueID(h.HeadingID, taken)
+			}
+		}
+	}
+}
+
+// recordExplicitIDs records in explicit the headings under doc whose ids were
+// written as {#id} rather than generated by p. The parser keeps the headings
+// it generated ids for in an unexported field, which is read rather than
+// parsing content a second time to tell the two apart.
+func recordExplicitIDs(p *parser.Parser, doc ast.Node, explicit map[*ast.Heading]bool) {
+	generated := make(map[uintptr]bool)
+	if auto := reflect.ValueOf(p).Elem().FieldByName("allHeadingsWithAutoID"); auto.Kind() == reflect.Slice {
+		for i := range auto.Len() {
+			generated[auto.Index(i).Pointer()] = true
+		}
+	}
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if h, ok := node.(*ast.Heading); ok && entering && h.HeadingID != "" && !generated[reflect.ValueOf(h).Pointer()] {
+			explicit[h] = true
+		}
+		return ast.GoToNext
+	})
+}
+
+// uniqueID returns id, or id with the first free numeric suffix, and marks
+// the result as taken
+func uniqueID(id string, taken map[string]bool) string {
+	unique := id
+	for n := 1; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", id, n)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// renderHeading writes a heading with a self-link to its id, placed as
+// configured
+func (b *builder) renderHeading(w io.Writer, h *ast.Heading, entering bool) (ast.WalkStatus, bool) {
+	placement := b.config.Anchors.Placement
+	if placement == anchorNone || h.HeadingID == "" || h.IsTitleblock {
+		return ast.GoToNext, false
+	}
+
+	id := template.HTMLEscapeString(h.HeadingID)
+	anchor := fmt.Sprintf(`<a class="anchor" href="#%s" aria-label="link to this section">%s</a>`,
+		id, template.HTMLEscapeString(b.config.Anchors.Symbol))
+
+	if entering {
+		attrs := append([]string{fmt.Sprintf(`id="%s"`, id)}, html.BlockAttrs(h)...)
+		fmt.Fprintf(w, "<h%d %s>", h.Level, strings.Join(attrs, " "))
+		if placement == anchorBefore {
+			io.WriteString(w, anchor+" ")
+		}
+	} else {
+		if placement == anchorAfter {
+			io.WriteString(w, " "+anchor)
+		}
+		fmt.Fprintf(w, "</h%d>\n", h.Level)
+	}
+
+	return ast.GoToNext, true
+}
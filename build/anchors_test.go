@@ -0,0 +1,27 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+func TestHeadingIDs(t *testing.T) {
+	content := "# Setup\n\n## Setup\n\n# Other {#setup}\n\n## Using `go test` and [docs](https://x.com/a)\n"
+	doc := parseMarkdown([]byte(content), "")
+
+	var ids []string
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if h, ok := node.(*ast.Heading); ok && entering {
+			ids = append(ids, h.HeadingID)
+		}
+		return ast.GoToNext
+	})
+
+	// The explicit id claims its name before the generated ones
+	want := []string{"setup-1", "setup-1-1", "setup", "using-go-test-and-docs-https-x-com-a"}
+	if !slices.Equal(ids, want) {
+		t.Errorf("got ids %q, want %q", ids, want)
+	}
+}
@@ -9,23 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
-	"strings"
 	texttemplate "text/template"
 	"time"
 )
 
-const (
-	contentDir  = "content"
-	templatesDir = "templates"
-	staticDir    = "static"
-	outputDir    = "public"
-	journalFile  = "journal/journal.txt"
-	timezone     = "America/Los_Angeles"
-
-	// feedEntryLimit caps RSS/Atom feeds to recent entries for performance
-	feedEntryLimit = 50
-)
-
 // Template names
 const (
 	tmplHome      = "home"
@@ -33,42 +20,86 @@ const (
 	tmplBlogPost  = "blog-post"
 	tmplBlogIndex = "blog-index"
 	tmplJournal   = "journal"
+	tmplTags      = "tags"
+	tmplTag       = "tag"
+	tmplAlias     = "alias"
 )
 
 // Content paths
 const (
 	pathBlogDir    = "blog"
 	pathJournalDir = "journal"
+	pathTagsDir    = "tags"
 )
 
 // builder handles the site build process
 type builder struct {
+	config        *siteConfig
+	opts          buildOptions
 	templates     map[string]*template.Template
 	feedTemplates map[string]*texttemplate.Template
+	shortcodes    map[string]shortcodeTemplates
+	images        *imageSet
 	site          *siteData
 	location      *time.Location
+
+	// outDir is where outputs are written during a build: a staging
+	// directory that replaces the configured output directory on success
+	outDir string
+
+	// Build cache state, see manifest.go
+	cache          *buildCache
+	templateInputs map[string]templateInfo
+	generatorKey   string
+	configKey      string
+	shortcodesKey  string
+	sectionKeys    map[string]string
+}
+
+// templateInfo identifies a template's inputs for the build cache
+type templateInfo struct {
+	key      string
+	sections []string
+}
+
+// buildOptions holds settings that vary per build rather than per site
+type buildOptions struct {
+	// drafts includes pages marked draft: true in the output
+	drafts bool
+	// cache skips outputs whose inputs are unchanged since the last build
+	cache bool
+	// force rebuilds every output while still recording a fresh manifest
+	force bool
+	// jobs is the number of pages collected and rendered concurrently
+	jobs int
+	// now overrides the current time when deciding what is published
+	now time.Time
 }
 
 // newBuilder creates a new builder instance
-func newBuilder() (*builder, error) {
+func newBuilder(cfg *siteConfig, opts buildOptions) (*builder, error) {
 	slog.Info("building site")
 
-	loc, err := time.LoadLocation(timezone)
+	loc, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
 		return nil, fmt.Errorf("loading timezone: %w", err)
 	}
 
-	je, err := loadJournal(loc)
+	je, err := loadJournal(cfg.Dirs.Journal, loc)
 	if err != nil {
 		return nil, fmt.Errorf("loading journal: %w", err)
 	}
 
 	b := &builder{
-		templates:     make(map[string]*template.Template),
-		feedTemplates: make(map[string]*texttemplate.Template),
+		config:         cfg,
+		opts:           opts,
+		templates:      make(map[string]*template.Template),
+		feedTemplates:  make(map[string]*texttemplate.Template),
+		templateInputs: make(map[string]templateInfo),
+		images:         &imageSet{images: make(map[string]*imageResult)},
 		site: &siteData{
+			Config:         cfg,
 			JournalEntries: je,
-			BlogPosts:      []blogPost{},
 		},
 		location: loc,
 	}
@@ -81,13 +112,40 @@ func newBuilder() (*builder, error) {
 		return nil, fmt.Errorf("loading feed templates: %w", err)
 	}
 
+	if err := b.loadShortcodes(); err != nil {
+		return nil, fmt.Errorf("loading shortcodes: %w", err)
+	}
+
 	return b, nil
 }
 
+// now returns the time used to decide which pages are published
+func (b *builder) now() time.Time {
+	if !b.opts.now.IsZero() {
+		return b.opts.now
+	}
+	return time.Now()
+}
+
 // build executes the full build process
 func (b *builder) build() error {
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
+	if err := b.config.Dirs.checkOutput(); err != nil {
+		return err
+	}
+	if err := checkReplaceable(b.config.Dirs.Output); err != nil {
+		return err
+	}
+
+	staging, err := newStagingDir(b.config.Dirs.Output)
+	if err != nil {
+		return err
+	}
+	// Once swapped into place the staging directory no longer exists
+	defer os.RemoveAll(staging)
+	b.outDir = staging
+
+	if err := b.openCache(); err != nil {
+		return fmt.Errorf("opening build cache: %w", err)
 	}
 
 	slog.Info("copying static files")
@@ -96,50 +154,216 @@ func (b *builder) build() error {
 	}
 
 	slog.Info("processing content")
-	pages, err := b.collectContent()
+	pages, posts, err := b.collectContent()
 	if err != nil {
 		return fmt.Errorf("collecting content: %w", err)
 	}
+	b.site.BlogPosts = posts
 
 	slices.SortStableFunc(b.site.BlogPosts, func(a, b blogPost) int {
-		return cmp.Compare(b.Date, a.Date)
+		return b.DateTime.Compare(a.DateTime)
 	})
+	b.site.Tags, err = buildTags(b.site.BlogPosts)
+	if err != nil {
+		return err
+	}
+	pages = append(b.paginate(pages), b.tagPages()...)
+	if err := checkPageOutputs(pages); err != nil {
+		return fmt.Errorf("collecting pages: %w", err)
+	}
+	b.site.Pages = sitemapPages(pages)
+
+	redirects, err := collectRedirects(pages)
+	if err != nil {
+		return fmt.Errorf("collecting redirects: %w", err)
+	}
+
+	if err := b.hashSections(); err != nil {
+		return fmt.Errorf("hashing site data: %w", err)
+	}
 
 	if err := b.renderPages(pages); err != nil {
 		return fmt.Errorf("rendering pages: %w", err)
 	}
 
+	slog.Info("writing redirects")
+	if err := b.writeRedirects(redirects); err != nil {
+		return fmt.Errorf("writing redirects: %w", err)
+	}
+
 	slog.Info("generating feeds")
 	if err := b.buildFeeds(); err != nil {
 		return fmt.Errorf("building feeds: %w", err)
 	}
 
+	if b.cache.incremental() {
+		slices.Sort(b.cache.rebuilt)
+		for _, rel := range b.cache.rebuilt {
+			slog.Info("rebuilt", "path", rel)
+		}
+	}
+
+	if err := writeOutputMarker(staging); err != nil {
+		return err
+	}
+
+	stale, err := staleFiles(b.config.Dirs.Output, staging)
+	if err != nil {
+		return fmt.Errorf("finding stale files: %w", err)
+	}
+	for _, rel := range stale {
+		slog.Info("removed stale file", "path", rel)
+	}
+
+	if err := swapDir(staging, b.config.Dirs.Output); err != nil {
+		return fmt.Errorf("replacing output directory: %w", err)
+	}
+
+	if err := b.cache.save(); err != nil {
+		return fmt.Errorf("saving build cache: %w", err)
+	}
+
 	slog.Info("build complete",
 		"pages", len(pages),
 		"blog_posts", len(b.site.BlogPosts),
-		"journal_entries", len(b.site.JournalEntries))
+		"journal_entries", len(b.site.JournalEntries),
+		"tags", len(b.site.Tags),
+		"redirects", len(redirects),
+		"jobs", b.opts.jobs,
+		"rebuilt", len(b.cache.rebuilt),
+		"unchanged", b.cache.unchanged,
+		"removed", len(stale))
+
+	return nil
+}
+
+// openCache loads the manifest of the previous build and computes the keys
+// shared by every output
+func (b *builder) openCache() error {
+	var path string
+	if b.opts.cache {
+		path = filepath.Join(b.config.Dirs.Cache, manifestFile)
+		b.generatorKey = generatorKey()
+	}
+
+	cache, err := openBuildCache(path, b.config.Dirs.Output, b.outDir)
+	if err != nil {
+		return err
+	}
+	if b.opts.force {
+		cache.prev = manifest{}
+	}
+	b.cache = cache
+
+	configKey, err := hashJSON(b.config)
+	if err != nil {
+		return err
+	}
+	// Options that only control how the build runs must not invalidate outputs
+	opts := b.opts
+	// The build time only matters through which pages are published, which
+	// the site data sections already capture
+	opts.cache, opts.force, opts.jobs, opts.now = false, false, 0, time.Time{}
+	b.configKey = hashKey(configKey, fmt.Sprintf("%+v", opts))
+
+	return nil
+}
+
+// hashSections computes the keys of the site data sections outputs depend on
+func (b *builder) hashSections() error {
+	journalKey, err := hashJSON(b.site.JournalEntries)
+	if err != nil {
+		return err
+	}
+	blogKey, err := hashJSON(b.site.BlogPosts)
+	if err != nil {
+		return err
+	}
+
+	// The sitemap only reads each page's URL and last modified date
+	var sitemap [][2]string
+	for _, pg := range b.site.Pages {
+		sitemap = append(sitemap, [2]string{pg.URL, pg.LastModified()})
+	}
+	pagesKey, err := hashJSON(sitemap)
+	if err != nil {
+		return err
+	}
 
+	b.sectionKeys = map[string]string{
+		sectionJournal: journalKey,
+		sectionBlog:    blogKey,
+		sectionPages:   pagesKey,
+	}
 	return nil
 }
 
+// sitemapPages returns the published pages sorted by URL, leaving out drafts
+func sitemapPages(pages []pageInfo) []*page {
+	var published []*page
+	for _, info := range pages {
+		if !info.page.Draft {
+			published = append(published, info.page)
+		}
+	}
+	slices.SortFunc(published, func(a, b *page) int {
+		return cmp.Compare(a.URL, b.URL)
+	})
+	return published
+}
+
+// pageSections returns the site data sections a page depends on through its
+// generated content rather than through its template
+func pageSections(t pathType) []string {
+	switch t {
+	case pathJournal:
+		return []string{sectionJournal}
+	case pathBlogIndex, pathTagIndex, pathTag:
+		return []string{sectionBlog}
+	default:
+		return nil
+	}
+}
+
+// outputKey combines the build-wide keys and the given site data sections with parts
+func (b *builder) outputKey(sections []string, parts ...string) string {
+	all := []string{b.generatorKey, b.configKey}
+	for _, s := range sections {
+		all = append(all, s, b.sectionKeys[s])
+	}
+	return hashKey(append(all, parts...)...)
+}
+
 // copyStatic copies static files to output directory
 func (b *builder) copyStatic() error {
+	staticDir := b.config.Dirs.Static
+
 	return filepath.WalkDir(staticDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		rel := strings.TrimPrefix(path, staticDir)
-		if rel == "" {
+		rel, err := filepath.Rel(staticDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
 			return nil
 		}
-		rel = strings.TrimPrefix(rel, string(filepath.Separator))
-		outputPath := filepath.Join(outputDir, rel)
+		outputPath := filepath.Join(b.outDir, rel)
 
 		if d.IsDir() {
 			return os.MkdirAll(outputPath, 0755)
 		}
 
+		key, err := hashFiles(path)
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", path, err)
+		}
+		if b.cache.current(outputPath, key) {
+			return nil
+		}
+
 		return copyFile(path, outputPath)
 	})
 }
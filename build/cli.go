@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// usage describes the available commands
+const usage = `usage: site <command> [flags]
+
+commands:
+  build              build the site into the output directory
+  check              build into a temporary directory to validate the site
+  serve              serve the site locally, rebuilding on changes
+  new <path>         create a draft content file, e.g. site new blog/my-post
+  journal add <url>  append an entry to the journal
+  highlight-css      print the stylesheet for highlighted code blocks
+
+with no command, site builds the site with the default flags
+run "site <command> -h" to see the flags for a command
+`
+
+// errUsage reports that the command line was invalid and usage was printed
+var errUsage = errors.New("invalid usage")
+
+// cliOptions holds flag values shared across commands
+type cliOptions struct {
+	source    string
+	output    string
+	baseURL   string
+	drafts    bool
+	jobs      int
+	now       string
+	logFormat string
+}
+
+// addCommonFlags registers flags accepted by every command
+func (o *cliOptions) addCommonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.source, "source", ".", "site source directory containing "+configFile)
+	fs.StringVar(&o.logFormat, "log-format", "text", "log output format: text or json")
+}
+
+// addBuildFlags registers flags accepted by commands that build the site
+func (o *cliOptions) addBuildFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.baseURL, "base-url", "", "override the configured base URL")
+	fs.BoolVar(&o.drafts, "drafts", false, "include draft pages for preview, excluded from feeds")
+	fs.StringVar(&o.now, "now", "", "build as if at this time (RFC 3339, or YYYY-MM-DDTHH:MM:SS or YYYY-MM-DD in the site timezone)")
+	fs.IntVar(&o.jobs, "jobs", runtime.GOMAXPROCS(0), "number of pages to collect and render concurrently")
+}
+
+// config loads the site configuration and applies flag overrides
+func (o *cliOptions) config() (*siteConfig, error) {
+	cfg, err := loadConfig(filepath.Join(o.source, configFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if o.output != "" {
+		cfg.Dirs.Output = filepath.Clean(o.output)
+	}
+	if o.baseURL != "" {
+		cfg.BaseURL = strings.TrimSuffix(o.baseURL, "/")
+	}
+
+	return cfg, nil
+}
+
+// buildOptions returns the per-build options selected by flags
+func (o *cliOptions) buildOptions(cfg *siteConfig) (buildOptions, error) {
+	opts := buildOptions{
+		drafts: o.drafts,
+		jobs:   o.jobs,
+	}
+
+	if o.jobs < 1 {
+		return opts, fmt.Errorf("invalid -jobs %d, must be positive", o.jobs)
+	}
+
+	if o.now != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return opts, fmt.Errorf("loading timezone: %w", err)
+		}
+
+		now, err := parseDate(o.now, loc)
+		if err != nil {
+			return opts, fmt.Errorf("invalid -now %q, expected RFC 3339, YYYY-MM-DDTHH:MM:SS or YYYY-MM-DD", o.now)
+		}
+		opts.now = now
+	}
+
+	return opts, nil
+}
+
+// parseFlags parses args into fs and configures logging
+func (o *cliOptions) parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	return setupLogging(o.logFormat)
+}
+
+// parseArgs parses args into fs. The flag package prints the error and usage
+// itself, so any error other than a request for help is reported as errUsage.
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return errUsage
+}
+
+// setupLogging configures the default logger for the requested format
+func setupLogging(format string) error {
+	switch format {
+	case "text":
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+// newFlagSet creates a flag set for a command that reports errors instead of exiting
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s\n\nflags:\n", strings.TrimSpace("site "+name+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// run dispatches to the command named by the first argument, building the
+// site when there is none
+func run(args []string) error {
+	if len(args) == 0 {
+		return runBuild(nil)
+	}
+
+	var err error
+	switch args[0] {
+	case "build":
+		err = runBuild(args[1:])
+	case "check":
+		err = runCheck(args[1:])
+	case "serve":
+		err = runServe(args[1:])
+	case "new":
+		err = runNew(args[1:])
+	case "journal":
+		err = runJournal(args[1:])
+	case "highlight-css":
+		err = runHighlightCSS(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return errUsage
+	}
+
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// runBuild builds the site into the output directory
+func runBuild(args []string) error {
+	var opts cliOptions
+	fs := newFlagSet("build", "")
+	opts.addCommonFlags(fs)
+	opts.addBuildFlags(fs)
+	fs.StringVar(&opts.output, "output", "", "override the configured output directory")
+	force := fs.Bool("force", false, "rebuild every output, ignoring the build cache")
+	if err := opts.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	// Drafts are previews and must never replace the production output
+	if opts.drafts && opts.output == "" {
+		return fmt.Errorf("-drafts requires -output so drafts are never written to %s", cfg.Dirs.Output)
+	}
+
+	bopts, err := opts.buildOptions(cfg)
+	if err != nil {
+		return err
+	}
+	bopts.cache = true
+	bopts.force = *force
+
+	b, err := newBuilder(cfg, bopts)
+	if err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+
+	if err := b.build(); err != nil {
+		return fmt.Errorf("build failed: %w", err)
+	}
+	return nil
+}
+
+// runCheck performs a full build into a temporary directory that is then discarded
+func runCheck(args []string) error {
+	var opts cliOptions
+	fs := newFlagSet("check", "")
+	opts.addCommonFlags(fs)
+	opts.addBuildFlags(fs)
+	if err := opts.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "site-check-")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	opts.output = tmp
+
+	cfg, err := opts.config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	bopts, err := opts.buildOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	b, err := newBuilder(cfg, bopts)
+	if err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+
+	if err := b.build(); err != nil {
+		return fmt.Errorf("check failed: %w", err)
+	}
+
+	slog.Info("check passed")
+	return nil
+}
+
+// runServe serves the site locally and rebuilds it when sources change
+func runServe(args []string) error {
+	var opts cliOptions
+	var addr string
+	var interval time.Duration
+	fs := newFlagSet("serve", "")
+	opts.addCommonFlags(fs)
+	opts.addBuildFlags(fs)
+	fs.StringVar(&addr, "addr", "localhost:8080", "address to listen on")
+	fs.DurationVar(&interval, "interval", 500*time.Millisecond, "how often to poll sources for changes")
+	if err := opts.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// Feed and absolute links should point at the local server unless overridden
+	if opts.baseURL == "" {
+		opts.baseURL = "http://" + addr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return serve(ctx, opts, addr, interval)
+}
+
+// runNew creates a new draft content file relative to the content directory
+func runNew(args []string) error {
+	var opts cliOptions
+	var title string
+	fs := newFlagSet("new", "<path>")
+	opts.addCommonFlags(fs)
+	fs.StringVar(&title, "title", "", "page title (defaults to the file name)")
+	if err := opts.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("loading timezone: %w", err)
+	}
+
+	rel := filepath.Clean(fs.Arg(0))
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("path %q must be relative to the content directory", fs.Arg(0))
+	}
+	rel = strings.TrimSuffix(rel, ".md") + ".md"
+	path := filepath.Join(cfg.Dirs.Content, rel)
+
+	if title == "" {
+		title = strings.ReplaceAll(strings.TrimSuffix(filepath.Base(rel), ".md"), "-", " ")
+	}
+
+	pg := &page{
+		Title: title,
+		Date:  time.Now().In(loc).Truncate(time.Second).Format(time.RFC3339),
+		Draft: true,
+	}
+
+	var sb strings.Builder
+	writeFrontmatter(&sb, pg)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	if _, err := f.WriteString(sb.String()); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	slog.Info("created content", "path", path)
+	return nil
+}
+
+// runJournal dispatches journal subcommands
+func runJournal(args []string) error {
+	if len(args) == 0 || args[0] != "add" {
+		fmt.Fprint(os.Stderr, "usage: site journal add [flags] <url>\n")
+		return errUsage
+	}
+
+	var opts cliOptions
+	fs := newFlagSet("journal add", "<url>")
+	opts.addCommonFlags(fs)
+	if err := opts.parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	cfg, err := opts.config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	entry, added, err := appendJournal(cfg.Dirs.Journal, fs.Arg(0), time.Now())
+	if err != nil {
+		return fmt.Errorf("adding journal entry: %w", err)
+	}
+
+	if !added {
+		slog.Info("entry already exists", "url", entry)
+		return nil
+	}
+	slog.Info("added journal entry", "url", entry)
+	return nil
+}
+
+// runHighlightCSS prints the stylesheet matching the classes of highlighted code
+func runHighlightCSS(args []string) error {
+	fs := newFlagSet("highlight-css", "")
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	return writeHighlightCSS(os.Stdout)
+}
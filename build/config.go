@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the name of the site configuration file
+const configFile = "site.yaml"
+
+// siteConfig holds site-specific settings loaded from site.yaml
+type siteConfig struct {
+	BaseURL     string           `yaml:"base_url"`
+	Title       string           `yaml:"title"`
+	Description string           `yaml:"description"`
+	Author      string           `yaml:"author"`
+	Language    string           `yaml:"language"`
+	Timezone    string           `yaml:"timezone"`
+	Dirs        dirsConfig       `yaml:"dirs"`
+	Feeds       feedsConfig      `yaml:"feeds"`
+	Pagination  paginationConfig `yaml:"pagination"`
+	Anchors     anchorsConfig    `yaml:"anchors"`
+	Images      imagesConfig     `yaml:"images"`
+	// StrictFrontmatter rejects frontmatter keys that are neither known
+	// fields nor params declared in the schema of the file's section
+	StrictFrontmatter bool `yaml:"strict_frontmatter"`
+	// Params maps a content section to the schema of the custom frontmatter
+	// params its pages may set. Sections without a schema allow any params.
+	Params map[string]map[string]paramSchema `yaml:"params"`
+}
+
+// dirsConfig holds the input and output locations of the site
+type dirsConfig struct {
+	Content   string `yaml:"content"`
+	Templates string `yaml:"templates"`
+	Static    string `yaml:"static"`
+	Output    string `yaml:"output"`
+	Journal   string `yaml:"journal"`
+	Cache     string `yaml:"cache"`
+	// root is the directory holding the configuration file
+	root string
+}
+
+// resolve makes relative paths relative to root and normalizes them so they
+// compare reliably against walked paths
+func (d *dirsConfig) resolve(root string) {
+	d.root = root
+	for _, p := range []*string{&d.Content, &d.Templates, &d.Static, &d.Output, &d.Journal, &d.Cache} {
+		if !filepath.IsAbs(*p) {
+			*p = filepath.Join(root, *p)
+		}
+	}
+}
+
+// checkOutput returns an error if the output directory is, or contains, the
+// site root or one of the source directories. Every build replaces the output
+// directory, so building into any of them would delete the site's sources.
+// The output may not be inside a source directory either, where later builds
+// would read it back as sources, though it usually lives in the site root.
+func (d *dirsConfig) checkOutput() error {
+	output, err := realPath(d.Output)
+	if err != nil {
+		return err
+	}
+
+	sources := []struct {
+		name string
+		path string
+		// dir reports whether the output must stay out of the source too
+		dir bool
+	}{
+		{"site root", d.root, false},
+		{"content directory", d.Content, true},
+		{"templates directory", d.Templates, true},
+		{"static directory", d.Static, true},
+		{"journal", d.Journal, false},
+		{"cache directory", d.Cache, true},
+	}
+	for _, src := range sources {
+		p, err := realPath(src.path)
+		if err != nil {
+			return err
+		}
+		if within(p, output) {
+			return fmt.Errorf("output directory %s would replace the %s %s", d.Output, src.name, src.path)
+		}
+		if src.dir && within(output, p) {
+			return fmt.Errorf("output directory %s is inside the %s %s", d.Output, src.name, src.path)
+		}
+	}
+	return nil
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath returns the absolute form of path with symlinks resolved, so paths
+// reaching the same directory compare equal. Paths that do not exist yet are
+// only made absolute.
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", path, err)
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real, nil
+	}
+	return abs, nil
+}
+
+// feedsConfig holds RSS/Atom feed settings
+type feedsConfig struct {
+	// JournalLimit and BlogLimit cap feeds to recent entries for performance
+	JournalLimit int `yaml:"journal_limit"`
+	BlogLimit    int `yaml:"blog_limit"`
+	// SummaryOnly leaves full post content out of blog feeds
+	SummaryOnly bool `yaml:"summary_only"`
+}
+
+// paginationConfig holds the number of items per listing page, where zero
+// puts every item on a single page
+type paginationConfig struct {
+	Journal int `yaml:"journal"`
+	Blog    int `yaml:"blog"`
+}
+
+// anchorsConfig holds the self-links added to headings
+type anchorsConfig struct {
+	// Symbol is the text of each heading's link
+	Symbol string `yaml:"symbol"`
+	// Placement puts the link before or after the heading text, or none to
+	// leave headings without links
+	Placement string `yaml:"placement"`
+}
+
+// imagesConfig holds the resized variants generated for content images
+type imagesConfig struct {
+	// Widths are the pixel widths of generated variants, skipping any not
+	// narrower than the original. Leaving it empty serves images as is.
+	Widths []int `yaml:"widths"`
+	// Sizes is the sizes attribute telling browsers how wide images display
+	Sizes string `yaml:"sizes"`
+	// Quality is the JPEG quality of resized variants, from 1 to 100
+	Quality int `yaml:"quality"`
+	// RequireAlt fails the build on images without alt text rather than
+	// logging a warning
+	RequireAlt bool `yaml:"require_alt"`
+}
+
+// paramSchema describes a custom frontmatter param a section allows
+type paramSchema struct {
+	// Type is one of string, bool, int, float, date, list or map
+	Type string `yaml:"type"`
+	// Required fails the build for pages of the section without the param
+	Required bool `yaml:"required"`
+}
+
+// Anchor link placements
+const (
+	anchorBefore = "before"
+	anchorAfter  = "after"
+	anchorNone   = "none"
+)
+
+// defaultConfig returns the configuration used for any unset values
+func defaultConfig() *siteConfig {
+	return &siteConfig{
+		Language: "en-us",
+		Timezone: "UTC",
+		Dirs: dirsConfig{
+			Content:   "content",
+			Templates: "templates",
+			Static:    "static",
+			Output:    "public",
+			Journal:   "journal/journal.txt",
+			Cache:     ".cache",
+		},
+		Feeds: feedsConfig{
+			JournalLimit: 50,
+			BlogLimit:    50,
+		},
+		Anchors: anchorsConfig{
+			Symbol:    "#",
+			Placement: anchorAfter,
+		},
+		Images: imagesConfig{
+			Widths:     []int{320, 640, 1280},
+			Sizes:      "(max-width: 680px) 100vw, 640px",
+			Quality:    85,
+			RequireAlt: true,
+		},
+	}
+}
+
+// loadConfig reads the site configuration, applying defaults for unset values.
+// Relative directories are resolved against the directory holding the file.
+func loadConfig(path string) (*siteConfig, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	cfg.Dirs.resolve(filepath.Dir(path))
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("validating %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// validate checks that required settings are present
+func (c *siteConfig) validate() error {
+	var errs []error
+
+	if c.BaseURL == "" {
+		errs = append(errs, errors.New("base_url is required"))
+	}
+	if c.Title == "" {
+		errs = append(errs, errors.New("title is required"))
+	}
+	if c.Feeds.JournalLimit < 1 || c.Feeds.BlogLimit < 1 {
+		errs = append(errs, errors.New("feed limits must be positive"))
+	}
+	if c.Pagination.Journal < 0 || c.Pagination.Blog < 0 {
+		errs = append(errs, errors.New("page sizes must not be negative"))
+	}
+	switch c.Anchors.Placement {
+	case anchorBefore, anchorAfter, anchorNone:
+	default:
+		errs = append(errs, fmt.Errorf("unknown anchor placement %q, expected before, after or none", c.Anchors.Placement))
+	}
+	for _, w := range c.Images.Widths {
+		if w < 1 {
+			errs = append(errs, fmt.Errorf("image width %d must be positive", w))
+		}
+	}
+	if c.Images.Quality < 1 || c.Images.Quality > 100 {
+		errs = append(errs, fmt.Errorf("image quality %d must be from 1 to 100", c.Images.Quality))
+	}
+	for _, section := range slices.Sorted(maps.Keys(c.Params)) {
+		for _, name := range slices.Sorted(maps.Keys(c.Params[section])) {
+			if typ := c.Params[section][name].Type; !slices.Contains(paramTypes, typ) {
+				errs = append(errs, fmt.Errorf("param %s of section %s has unknown type %q, expected one of %s", name, section, typ, strings.Join(paramTypes, ", ")))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,28 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"log/slog"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
-	"gopkg.in/yaml.v3"
 )
 
-// collectContent walks the content directory and collects all pages
-func (b *builder) collectContent() ([]pageInfo, error) {
-	var pages []pageInfo
+// collectContent walks the content directory and collects all pages and blog
+// posts, processing files concurrently while preserving walk order
+func (b *builder) collectContent() ([]pageInfo, []blogPost, error) {
+	var paths []string
 
-	err := filepath.WalkDir(contentDir, func(path string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(b.config.Dirs.Content, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -31,85 +36,191 @@ func (b *builder) collectContent() ([]pageInfo, error) {
 			return nil
 		}
 
-		info, err := b.collectPage(path)
-		if err != nil {
-			return fmt.Errorf("collecting %s: %w", path, err)
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	infos := make([]*pageInfo, len(paths))
+	err = forEachParallel(len(paths), b.opts.jobs, func(i int) error {
+		info, err := b.collectPage(paths[i])
+		// Diagnostics already name the file
+		var diag *diagnostic
+		if errors.As(err, &diag) {
+			return err
 		}
-		if info != nil {
-			pages = append(pages, *info)
+		if err != nil {
+			return fmt.Errorf("collecting %s: %w", paths[i], err)
 		}
+		infos[i] = info
 		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return pages, err
+	var pages []pageInfo
+	posts := []blogPost{}
+	for _, info := range infos {
+		if info == nil {
+			continue
+		}
+		pages = append(pages, *info)
+		if info.post != nil {
+			posts = append(posts, *info.post)
+		}
+	}
+
+	return pages, posts, nil
 }
 
-// collectPage processes a single content file
+// collectPage processes a single content file. It must not modify shared
+// builder state since pages are collected concurrently.
 func (b *builder) collectPage(path string) (*pageInfo, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading file: %w", err)
 	}
+	content = normalizeSource(content)
 
-	pg, mdContent, err := parseFrontmatter(content)
+	pg, mdContent, err := b.parseFrontmatter(path, content)
 	if err != nil {
-		return nil, fmt.Errorf("parsing frontmatter: %w", err)
+		return nil, err
 	}
 
-	if pg.Draft {
+	// Drafts are only rendered in preview builds
+	if pg.Draft && !b.opts.drafts {
+		return nil, nil
+	}
+
+	if !b.isLive(path, pg) {
 		return nil, nil
 	}
 
 	// Store raw markdown content with frontmatter for .md output
 	pg.MarkdownSource = content
 
-	pg.Content = template.HTML(renderMarkdown(mdContent))
 	pg.URL = b.determineURL(path)
 	pg.Slug = b.determineSlug(path)
 
 	templateName := b.determineTemplate(path, pg)
 	outputPath := b.determineOutputPath(path)
 
-	pathClass := classifyPath(path)
+	pathClass := b.classifyPath(path)
+
+	// Relative references in a bundle point at files in its directory, which
+	// is published under the page URL
+	var base string
+	var assets []string
+	if b.isBundle(path, pathClass) {
+		base = pg.URL + "/"
+		assets, err = bundleAssets(filepath.Dir(path))
+		if err != nil {
+			return nil, fmt.Errorf("listing bundle files: %w", err)
+		}
+	}
+
+	expanded, err := b.expandShortcodes(mdContent, base)
+	if err != nil {
+		return nil, fmt.Errorf("expanding shortcodes: %w", err)
+	}
+	pg.MarkdownBody = resolveMarkdownURLs(expanded.twin, base)
+
+	doc := expanded.parse(expanded.body, base)
+	if err := b.processImages(doc); err != nil {
+		return nil, err
+	}
+	rendered, err := b.renderContent(doc)
+	if err != nil {
+		return nil, err
+	}
+	pg.Content = template.HTML(rendered)
+	if pg.ShowTOC {
+		pg.TOC = buildTOC(doc, pg.TOCMinDepth, pg.TOCMaxDepth)
+	}
+	pg.WordCount = countWords(doc)
+	pg.ReadingTime = readingTime(pg.WordCount)
 
 	// For blog posts, derive title from filename if not set
 	if pathClass == pathBlogPost && pg.Title == "" {
 		pg.Title = strings.ReplaceAll(pg.Slug, "-", " ")
 	}
 
-	// Add blog posts to site data
+	info := &pageInfo{
+		page:         pg,
+		path:         path,
+		outputPath:   outputPath,
+		templateName: templateName,
+		pathType:     pathClass,
+		assets:       assets,
+	}
+
+	// Blog posts are added to site data once all pages are collected
 	if pathClass == pathBlogPost {
-		post := blogPost{
-			Title:   pg.Title,
-			Slug:    pg.Slug,
-			Date:    pg.Date,
-			Content: pg.Content,
+		if err := validateTags(pg.Tags); err != nil {
+			return nil, err
 		}
 
-		if pg.Date != "" {
-			t, err := parseDate(pg.Date, b.location)
-			if err != nil {
-				return nil, err
-			}
-			post.DateRSS = formatDateRSS(t)
-			post.DateAtom = formatDateAtom(t)
+		summary, err := b.renderSummary(pg.Summary, expanded, base)
+		if err != nil {
+			return nil, fmt.Errorf("rendering summary: %w", err)
 		}
 
-		b.site.BlogPosts = append(b.site.BlogPosts, post)
+		post := &blogPost{
+			Title:       pg.Title,
+			Summary:     template.HTML(summary),
+			WordCount:   pg.WordCount,
+			ReadingTime: pg.ReadingTime,
+			Slug:        pg.Slug,
+			Date:        pg.Date,
+			DateTime:    pg.DateTime,
+			Updated:     pg.Updated,
+			UpdatedTime: pg.UpdatedTime,
+			Content:     pg.Content,
+			Tags:        pg.Tags,
+			Draft:       pg.Draft,
+			Params:      pg.Params,
+		}
+
+		if !pg.DateTime.IsZero() {
+			post.DateRSS = formatDateRSS(pg.DateTime)
+			post.DateAtom = formatDateAtom(pg.DateTime)
+			post.UpdatedAtom = post.DateAtom
+		}
+
+		if !pg.UpdatedTime.IsZero() {
+			post.UpdatedAtom = formatDateAtom(pg.UpdatedTime)
+		}
+
+		info.post = post
 	}
 
-	return &pageInfo{
-		page:         pg,
-		path:         path,
-		outputPath:   outputPath,
-		templateName: templateName,
-		pathType:     pathClass,
-	}, nil
+	return info, nil
+}
+
+// isLive reports whether a page is published at the build time: scheduled
+// pages are held back until their date and expired pages are dropped
+func (b *builder) isLive(path string, pg *page) bool {
+	now := b.now()
+
+	if !pg.DateTime.IsZero() && now.Before(pg.DateTime) {
+		slog.Info("holding back scheduled page", "path", path, "date", pg.Date)
+		return false
+	}
+
+	if !pg.ExpiresTime.IsZero() && !now.Before(pg.ExpiresTime) {
+		slog.Info("skipping expired page", "path", path, "expires", pg.Expires)
+		return false
+	}
+
+	return true
 }
 
 // classifyPath determines the type of content based on path
-func classifyPath(path string) pathType {
-	rel := relPath(path)
+func (b *builder) classifyPath(path string) pathType {
+	rel := b.relPath(path)
 
 	if rel == "index.md" {
 		return pathHome
@@ -136,7 +247,7 @@ func (b *builder) determineTemplate(path string, pg *page) string {
 		return pg.Template
 	}
 
-	switch classifyPath(path) {
+	switch b.classifyPath(path) {
 	case pathHome:
 		return tmplHome
 	case pathJournal:
@@ -164,24 +275,50 @@ func isDirIndex(rel string) (dir string, ok bool) {
 	return "", false
 }
 
+// isBundle reports whether the page is a directory-based page whose
+// non-markdown siblings are published next to it. The home page and section
+// indexes are not bundles since their directories hold other pages.
+func (b *builder) isBundle(path string, t pathType) bool {
+	if _, ok := isDirIndex(b.relPath(path)); !ok {
+		return false
+	}
+	return t == pathBlogPost || t == pathPage
+}
+
+// bundleAssets returns the non-markdown files under a bundle directory
+func bundleAssets(dir string) ([]string, error) {
+	var assets []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		assets = append(assets, path)
+		return nil
+	})
+	return assets, err
+}
+
 // determineOutputPath determines the output file path
 func (b *builder) determineOutputPath(path string) string {
-	rel := relPath(path)
+	rel := b.relPath(path)
 
 	if isRootIndex(rel) {
-		return filepath.Join(outputDir, "index.html")
+		return filepath.Join(b.outDir, "index.html")
 	}
 
 	if dir, ok := isDirIndex(rel); ok {
-		return filepath.Join(outputDir, dir+".html")
+		return filepath.Join(b.outDir, dir+".html")
 	}
 
-	return filepath.Join(outputDir, strings.TrimSuffix(rel, ".md")+".html")
+	return filepath.Join(b.outDir, strings.TrimSuffix(rel, ".md")+".html")
 }
 
 // determineURL determines the URL for a page
 func (b *builder) determineURL(path string) string {
-	rel := relPath(path)
+	rel := b.relPath(path)
 
 	if isRootIndex(rel) {
 		return "/"
@@ -199,67 +336,325 @@ func (b *builder) determineURL(path string) string {
 	return "/" + strings.ReplaceAll(dir, string(filepath.Separator), "/") + "/" + slug
 }
 
-// determineSlug extracts the slug from a path
+// determineSlug extracts the slug from a path, using the directory name for
+// a directory's index.md
 func (b *builder) determineSlug(path string) string {
+	if dir, ok := isDirIndex(b.relPath(path)); ok {
+		return filepath.Base(dir)
+	}
 	return strings.TrimSuffix(filepath.Base(path), ".md")
 }
 
-// parseFrontmatter extracts frontmatter from content
-func parseFrontmatter(content []byte) (*page, []byte, error) {
-	pg := &page{}
+// summaryMarker separates a post's summary from the rest of its content
+const summaryMarker = "<!--more-->"
+
+// renderMarkdown converts markdown content to HTML, resolving relative link
+// and image destinations against base when it is set
+func (b *builder) renderMarkdown(content []byte, base string) []byte {
+	return b.renderHTML(parseMarkdown(content, base))
+}
+
+// markdownExtensions are the markdown syntax extensions content may use
+const markdownExtensions = parser.CommonExtensions | parser.SuperSubscript
+
+// parseMarkdown parses markdown content, giving every heading a unique id and
+// resolving relative link and image destinations against base when it is set
+func parseMarkdown(content []byte, base string) ast.Node {
+	explicit := make(map[*ast.Heading]bool)
+	doc := parseFragment(content, base, explicit)
+	assignHeadingIDs(doc, explicit)
+	return doc
+}
+
+// parseFragment parses markdown content that is part of a page, leaving ids
+// to be made unique across the page. Headings with explicit ids are recorded
+// in explicit.
+func parseFragment(content []byte, base string, explicit map[*ast.Heading]bool) ast.Node {
+	// Generated ids are derived from each heading's source text, keeping the
+	// anchors of existing pages stable
+	p := parser.NewWithExtensions(markdownExtensions | parser.AutoHeadingIDs)
+	doc := p.Parse(content)
 
-	str := string(content)
-	if !strings.HasPrefix(str, "---\n") {
-		return pg, content, nil
+	recordExplicitIDs(p, doc, explicit)
+	if base != "" {
+		resolveRelativeURLs(doc, base)
 	}
+	return doc
+}
+
+// renderHTML renders a parsed markdown node to HTML
+func (b *builder) renderHTML(node ast.Node) []byte {
+	opts := html.RendererOptions{
+		Flags:          html.CommonFlags,
+		RenderNodeHook: b.renderNode,
+	}
+	renderer := html.NewRenderer(opts)
 
-	rest := str[4:]
-	endIndex := strings.Index(rest, "\n---\n")
-	var frontmatterStr, remaining string
+	return markdown.Render(node, renderer)
+}
 
-	if endIndex != -1 {
-		frontmatterStr = rest[:endIndex]
-		remaining = rest[endIndex+5:]
-	} else if strings.HasSuffix(rest, "\n---") {
-		frontmatterStr = rest[:len(rest)-4]
-		remaining = ""
-	} else {
-		return pg, content, nil
+// renderContent renders the shortcodes under node and then node itself to
+// HTML
+func (b *builder) renderContent(node ast.Node) ([]byte, error) {
+	if err := b.renderShortcodes(node); err != nil {
+		return nil, err
+	}
+	return b.renderHTML(node), nil
+}
+
+// renderSummary renders a post's summary from its summary frontmatter, the
+// content before the summary marker, or else its first paragraph
+func (b *builder) renderSummary(summary string, content expandedMarkdown, base string) ([]byte, error) {
+	if summary != "" {
+		return b.renderMarkdown([]byte(summary), base), nil
 	}
 
-	var fm frontmatter
-	if err := yaml.Unmarshal([]byte(frontmatterStr), &fm); err != nil {
-		return nil, nil, fmt.Errorf("invalid YAML: %w", err)
+	if before, ok := cutSummary(content.body); ok {
+		return b.renderContent(content.parse(before, base))
 	}
 
-	if fm.Date != "" {
-		if _, err := time.Parse(time.DateOnly, fm.Date); err != nil {
-			return nil, nil, fmt.Errorf("invalid date format %q, expected YYYY-MM-DD: %w", fm.Date, err)
+	doc := content.parse(content.body, base)
+	for _, child := range doc.GetChildren() {
+		if p, ok := child.(*ast.Paragraph); ok {
+			return b.renderContent(p)
+		}
+	}
+	return nil, nil
+}
+
+// cutSummary returns the markdown content before the first summary marker
+// outside code, reporting whether there is one
+func cutSummary(content []byte) ([]byte, bool) {
+	code := codeRanges(content)
+	for pos := 0; ; {
+		i := bytes.Index(content[pos:], []byte(summaryMarker))
+		if i < 0 {
+			return nil, false
 		}
+		if !inRanges(code, pos+i) {
+			return content[:pos+i], true
+		}
+		pos += i + len(summaryMarker)
 	}
+}
+
+// wordsPerMinute is the reading speed used to estimate reading time
+const wordsPerMinute = 200
+
+// countWords counts the words of prose under doc, leaving out code blocks and
+// image alt text
+func countWords(doc ast.Node) int {
+	words := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.CodeBlock, *ast.Image:
+			return ast.SkipChildren
+		case *ast.Text:
+			words += len(strings.Fields(string(n.Literal)))
+		case *ast.Code:
+			words += len(strings.Fields(string(n.Literal)))
+		}
+		return ast.GoToNext
+	})
+	return words
+}
+
+// readingTime estimates the minutes needed to read words, rounding up so
+// any content takes at least a minute
+func readingTime(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
 
-	pg.Title = fm.Title
-	pg.Description = fm.Description
-	pg.Date = fm.Date
-	pg.Template = fm.Template
-	pg.Draft = fm.Draft
+// resolveRelativeURLs rewrites relative link and image destinations under
+// doc to absolute paths resolved against base
+func resolveRelativeURLs(doc ast.Node, base string) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
 
-	return pg, []byte(remaining), nil
+		switch n := node.(type) {
+		case *ast.Link:
+			if n.NoteID == 0 {
+				n.Destination = []byte(resolveRelativeURL(string(n.Destination), base))
+			}
+		case *ast.Image:
+			n.Destination = []byte(resolveRelativeURL(string(n.Destination), base))
+		}
+		return ast.GoToNext
+	})
 }
 
-// renderMarkdown converts markdown content to HTML
-func renderMarkdown(content []byte) []byte {
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.SuperSubscript
-	p := parser.NewWithExtensions(extensions)
-	doc := p.Parse(content)
+// resolveRelativeURL resolves a relative path against base, returning
+// absolute URLs, absolute paths and fragments unchanged
+func resolveRelativeURL(dest, base string) string {
+	if base == "" {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return dest
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return dest
+	}
+	return baseURL.ResolveReference(u).String()
+}
 
-	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: renderLink,
+// markdownDestination matches the destination of an inline link or image,
+// such as cat.png in ![cat](cat.png "title"), in group 1 or, when written in
+// angle brackets, group 2
+var markdownDestination = regexp.MustCompile(`\]\(\s*(?:<([^<>\n]*)>|([^\s()<>]+))`)
+
+// markdownReference matches the destination of a link reference definition,
+// such as cat.png in [cat]: cat.png, leaving out footnote definitions
+var markdownReference = regexp.MustCompile(`(?m)^ {0,3}\[[^\]^\n][^\]\n]*\]:[ \t]*(?:<([^<>\n]*)>|(\S+))`)
+
+// resolveMarkdownURLs rewrites relative link and image destinations in
+// markdown content to absolute paths resolved against base, as
+// resolveRelativeURLs does for parsed content. Code is left as written.
+func resolveMarkdownURLs(content []byte, base string) []byte {
+	if base == "" {
+		return content
 	}
-	renderer := html.NewRenderer(opts)
 
-	return markdown.Render(doc, renderer)
+	code := codeRanges(content)
+	var matches [][]int
+	for _, re := range []*regexp.Regexp{markdownDestination, markdownReference} {
+		for _, m := range re.FindAllSubmatchIndex(content, -1) {
+			if !inRanges(code, m[0]) {
+				matches = append(matches, m)
+			}
+		}
+	}
+	slices.SortFunc(matches, func(a, b []int) int {
+		return cmp.Compare(a[0], b[0])
+	})
+
+	var out bytes.Buffer
+	pos := 0
+	for _, m := range matches {
+		// The destination is in whichever group matched
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		out.Write(content[pos:start])
+		out.WriteString(resolveRelativeURL(string(content[start:end]), base))
+		pos = end
+	}
+	out.Write(content[pos:])
+	return out.Bytes()
+}
+
+// codeRanges returns the byte ranges of fenced code blocks and code spans in
+// markdown content, sorted by position
+func codeRanges(content []byte) [][2]int {
+	var ranges [][2]int
+
+	// Fenced code blocks run from an opening fence of three or more backticks
+	// or tildes to a closing fence at least as long, or the end of the content
+	var fenceChar byte
+	var fenceLen, fenceStart, textStart int
+	for pos := 0; pos < len(content); {
+		end := bytes.IndexByte(content[pos:], '\n') + pos + 1
+		if end == pos {
+			end = len(content)
+		}
+		line := content[pos:end]
+		trimmed := bytes.TrimLeft(line, " ")
+		indented := len(line)-len(trimmed) > 3
+
+		run := 0
+		if len(trimmed) > 0 && (trimmed[0] == '`' || trimmed[0] == '~') {
+			for run < len(trimmed) && trimmed[run] == trimmed[0] {
+				run++
+			}
+		}
+
+		switch {
+		case fenceLen == 0 && !indented && run >= 3 && !(trimmed[0] == '`' && bytes.IndexByte(trimmed[run:], '`') >= 0):
+			ranges = append(ranges, codeSpans(content, textStart, pos)...)
+			fenceChar, fenceLen, fenceStart = trimmed[0], run, pos
+		case fenceLen > 0 && !indented && run >= fenceLen && trimmed[0] == fenceChar && len(bytes.TrimSpace(trimmed[run:])) == 0:
+			ranges = append(ranges, [2]int{fenceStart, end})
+			fenceLen, textStart = 0, end
+		}
+		pos = end
+	}
+
+	if fenceLen > 0 {
+		return append(ranges, [2]int{fenceStart, len(content)})
+	}
+	return append(ranges, codeSpans(content, textStart, len(content))...)
+}
+
+// codeSpans returns the ranges of the code spans in content[start:end]: runs
+// of backticks closed by a run of the same length within the same block
+func codeSpans(content []byte, start, end int) [][2]int {
+	var ranges [][2]int
+	for pos := start; pos < end; {
+		open := bytes.IndexByte(content[pos:end], '`')
+		if open < 0 {
+			break
+		}
+		open += pos
+
+		n := 1
+		for open+n < end && content[open+n] == '`' {
+			n++
+		}
+		pos = open + n
+
+		// An escaped backtick does not open a span
+		if open > 0 && content[open-1] == '\\' {
+			pos = open + 1
+			continue
+		}
+
+		for close := pos; close < end; {
+			i := bytes.IndexByte(content[close:end], '`')
+			if i < 0 {
+				break
+			}
+			i += close
+			m := 1
+			for i+m < end && content[i+m] == '`' {
+				m++
+			}
+			if m == n {
+				if !blankLine.Match(content[pos:i]) {
+					ranges = append(ranges, [2]int{open, i + m})
+					pos = i + m
+				}
+				break
+			}
+			close = i + m
+		}
+	}
+	return ranges
+}
+
+// blankLine matches a blank line, which ends the block a code span is in
+var blankLine = regexp.MustCompile(`\n[ \t]*\n`)
+
+// inRanges reports whether pos falls in one of the sorted ranges
+func inRanges(ranges [][2]int, pos int) bool {
+	_, found := slices.BinarySearchFunc(ranges, pos, func(r [2]int, pos int) int {
+		switch {
+		case r[1] <= pos:
+			return -1
+		case r[0] > pos:
+			return 1
+		default:
+			return 0
+		}
+	})
+	return found
 }
 
 // isSafeURL checks if a URL scheme is safe (not javascript:, data:, etc.)
@@ -283,13 +678,35 @@ func isSafeURL(dest string) bool {
 	}
 }
 
-// renderLink adds target="_blank" and rel="noopener" to external links
-func renderLink(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
-	link, ok := node.(*ast.Link)
-	if !ok {
+// renderNode renders the nodes whose HTML the site customizes, leaving the
+// rest to the default renderer
+func (b *builder) renderNode(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	switch n := node.(type) {
+	case *shortcodeNode:
+		if entering {
+			w.Write(n.html)
+		}
+		return ast.SkipChildren, true
+	case *ast.Heading:
+		return b.renderHeading(w, n, entering)
+	case *ast.Link:
+		return renderLink(w, n, entering)
+	case *ast.CodeBlock:
+		return renderCodeBlock(w, n)
+	case *ast.Paragraph:
+		if img := standaloneImage(n); img != nil {
+			return b.renderFigure(w, img, entering)
+		}
+		return ast.GoToNext, false
+	case *ast.Image:
+		return b.renderImage(w, n, entering)
+	default:
 		return ast.GoToNext, false
 	}
+}
 
+// renderLink adds target="_blank" and rel="noopener" to external links
+func renderLink(w io.Writer, link *ast.Link, entering bool) (ast.WalkStatus, bool) {
 	if entering {
 		dest := string(link.Destination)
 
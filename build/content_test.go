@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCutSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		ok      bool
+	}{
+		{"marker", "intro\n\n<!--more-->\n\nrest\n", "intro\n\n", true},
+		{"no marker", "intro\n\nrest\n", "", false},
+		{"code span", "use `<!--more-->` to split\n\nrest\n", "", false},
+		{"fenced code", "intro\n\n```html\n<!--more-->\n```\n\nrest\n", "", false},
+		{"after code", "use `<!--more-->`\n\n<!--more-->\n\nrest\n", "use `<!--more-->`\n\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cutSummary([]byte(tt.content))
+			if ok != tt.ok || string(got) != tt.want {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
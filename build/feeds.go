@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 )
 
-// feedMapping maps feed template paths to output file names
+// feedMapping maps feed template paths to output file names. The sitemap is
+// rendered the same way as the feeds.
 var feedMapping = []struct {
 	template string
 	output   string
@@ -14,6 +16,17 @@ var feedMapping = []struct {
 	{"feeds/journal.atom", "journal.atom"},
 	{"feeds/blog.xml", "blog.xml"},
 	{"feeds/blog.atom", "blog.atom"},
+	{"sitemap.xml", "sitemap.xml"},
+}
+
+// tagFeedMapping maps per-tag feed templates to the extension of the feed
+// written next to each tag's listing page
+var tagFeedMapping = []struct {
+	template  string
+	extension string
+}{
+	{"feeds/tag.xml", ".xml"},
+	{"feeds/tag.atom", ".atom"},
 }
 
 // buildFeeds generates RSS and Atom feeds
@@ -24,11 +37,57 @@ func (b *builder) buildFeeds() error {
 			return fmt.Errorf("feed template %s not found", fm.template)
 		}
 
-		outputPath := filepath.Join(outputDir, fm.output)
-		if err := writeTemplate(outputPath, tmpl, b.site); err != nil {
+		outputPath := filepath.Join(b.outDir, fm.output)
+
+		inputs := b.templateInputs[fm.template]
+		if b.cache.current(outputPath, b.outputKey(inputs.sections, inputs.key)) {
+			continue
+		}
+
+		data := templateData{
+			Site: b.site,
+		}
+
+		if err := writeTemplate(outputPath, tmpl, data); err != nil {
 			return fmt.Errorf("rendering feed %s: %w", fm.output, err)
 		}
 	}
 
+	return b.buildTagFeeds()
+}
+
+// buildTagFeeds generates RSS and Atom feeds for each tag
+func (b *builder) buildTagFeeds() error {
+	for _, fm := range tagFeedMapping {
+		tmpl, ok := b.feedTemplates[fm.template]
+		if !ok {
+			return fmt.Errorf("feed template %s not found", fm.template)
+		}
+		inputs := b.templateInputs[fm.template]
+		sections := append([]string{sectionBlog}, inputs.sections...)
+
+		for i := range b.site.Tags {
+			t := &b.site.Tags[i]
+			outputPath := filepath.Join(b.outDir, pathTagsDir, t.Slug+fm.extension)
+
+			if b.cache.current(outputPath, b.outputKey(sections, inputs.key, t.Slug)) {
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+				return fmt.Errorf("creating directory for %s: %w", outputPath, err)
+			}
+
+			data := templateData{
+				Site: b.site,
+				Tag:  t,
+			}
+
+			if err := writeTemplate(outputPath, tmpl, data); err != nil {
+				return fmt.Errorf("rendering feed for tag %s: %w", t.Name, err)
+			}
+		}
+	}
+
 	return nil
 }
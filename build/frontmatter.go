@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// diagnostic is an error at a position in a content file
+type diagnostic struct {
+	path string
+	line int // zero when unknown
+	col  int // zero when unknown
+	msg  string
+}
+
+// Error formats the diagnostic as path:line:col: message, leaving out an
+// unknown position
+func (d *diagnostic) Error() string {
+	switch {
+	case d.line > 0 && d.col > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", d.path, d.line, d.col, d.msg)
+	case d.line > 0:
+		return fmt.Sprintf("%s:%d: %s", d.path, d.line, d.msg)
+	default:
+		return fmt.Sprintf("%s: %s", d.path, d.msg)
+	}
+}
+
+// frontmatterFormat describes how frontmatter in one format is fenced and
+// parsed into a YAML node, so every format is checked the same way
+type frontmatterFormat struct {
+	name string
+	// open and close are the lines fencing the frontmatter
+	open, close string
+	// fenced reports whether the fences belong to the frontmatter itself,
+	// as the braces of a JSON object do
+	fenced bool
+	parse  func(raw []byte) (*yaml.Node, error)
+}
+
+// frontmatterFormats are the supported frontmatter formats
+var frontmatterFormats = []frontmatterFormat{
+	{name: "YAML", open: "---", close: "---", parse: parseYAML},
+	{name: "TOML", open: "+++", close: "+++", parse: parseTOML},
+	{name: "JSON", open: "{", close: "}", fenced: true, parse: parseJSON},
+}
+
+// syntaxError is a frontmatter syntax error at a line and column of the
+// frontmatter, either of which is zero when unknown
+type syntaxError struct {
+	line, col int
+	msg       string
+}
+
+// Error returns the message, leaving the position to the diagnostic
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+// frontmatterDecoder decodes frontmatter into a page, collecting a
+// diagnostic for every problem rather than stopping at the first
+type frontmatterDecoder struct {
+	path string
+	// offset is the number of lines in the file before the frontmatter
+	offset int
+	diags  []*diagnostic
+}
+
+// errorf records a diagnostic at n, or at no position when n is nil
+func (d *frontmatterDecoder) errorf(n *yaml.Node, format string, args ...any) {
+	diag := &diagnostic{path: d.path, msg: fmt.Sprintf(format, args...)}
+	if n != nil && n.Line > 0 {
+		diag.line = n.Line + d.offset
+		diag.col = n.Column
+	}
+	d.diags = append(d.diags, diag)
+}
+
+// syntaxError records an error parsing frontmatter in the named format,
+// keeping its position when known
+func (d *frontmatterDecoder) syntaxError(format string, err error) {
+	diag := &diagnostic{path: d.path, msg: fmt.Sprintf("invalid %s: %v", format, err)}
+	var se *syntaxError
+	if errors.As(err, &se) && se.line > 0 {
+		diag.line = se.line + d.offset
+		diag.col = se.col
+	}
+	d.diags = append(d.diags, diag)
+}
+
+// err returns the recorded diagnostics joined in file order, or nil
+func (d *frontmatterDecoder) err() error {
+	slices.SortStableFunc(d.diags, func(a, b *diagnostic) int {
+		return cmp.Or(cmp.Compare(a.line, b.line), cmp.Compare(a.col, b.col))
+	})
+	errs := make([]error, len(d.diags))
+	for i, diag := range d.diags {
+		errs[i] = diag
+	}
+	return errors.Join(errs...)
+}
+
+// normalizeSource strips a byte order mark and converts CRLF line endings so
+// files saved by Windows editors parse like any other
+func normalizeSource(content []byte) []byte {
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// parseFrontmatter extracts the frontmatter of the content file at path,
+// returning the page it describes and the markdown that follows. Unknown keys
+// are kept as params unless the site uses strict frontmatter, in which case
+// they must be declared in the param schema of the file's section.
+func (b *builder) parseFrontmatter(path string, content []byte) (*page, []byte, error) {
+	pg := &page{}
+	d := &frontmatterDecoder{path: path}
+
+	format, raw, body, err := splitFrontmatter(content)
+	if err != nil {
+		d.diags = append(d.diags, &diagnostic{path: path, line: 1, col: 1, msg: err.Error()})
+		return nil, nil, d.err()
+	}
+	if format == nil {
+		return pg, content, nil
+	}
+
+	// Unless the fences are part of it, the frontmatter starts on the line
+	// after the opening fence
+	if !format.fenced {
+		d.offset = 1
+	}
+
+	root, err := format.parse(raw)
+	if err != nil {
+		d.syntaxError(format.name, err)
+		return nil, nil, d.err()
+	}
+	if root.Kind != yaml.MappingNode {
+		d.errorf(root, "frontmatter must be a mapping of keys to values, got %s", describeNode(root))
+		return nil, nil, d.err()
+	}
+
+	var fm frontmatter
+	nodes := b.decodeFrontmatter(d, path, root, &fm)
+	b.applyFrontmatter(d, nodes, &fm, pg)
+
+	if err := d.err(); err != nil {
+		return nil, nil, err
+	}
+	return pg, body, nil
+}
+
+// decodeFrontmatter decodes the keys of a frontmatter mapping into fm,
+// checking custom params against the schema of the file's section. It
+// returns the value node of each key.
+func (b *builder) decodeFrontmatter(d *frontmatterDecoder, path string, root *yaml.Node, fm *frontmatter) map[string]*yaml.Node {
+	fields := fm.fields()
+	schema, hasSchema := b.config.Params[b.contentSection(path)]
+	nodes := make(map[string]*yaml.Node)
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name := key.Value
+
+		if _, ok := nodes[name]; ok {
+			d.errorf(key, "duplicate key %q", name)
+			continue
+		}
+		nodes[name] = value
+
+		if field, ok := fields[name]; ok {
+			if n := mismatchedNode(value, field.Type()); n != nil {
+				d.errorf(n, "%s must be %s, got %s", name, describeType(field.Type()), describeNode(n))
+				continue
+			}
+			if err := value.Decode(field.Addr().Interface()); err != nil {
+				d.errorf(value, "%s must be %s, got %s", name, describeType(field.Type()), describeNode(value))
+			}
+			continue
+		}
+
+		// Every other key is a custom param
+		var v any
+		if err := value.Decode(&v); err != nil {
+			d.errorf(value, "invalid %s: %v", name, err)
+			continue
+		}
+
+		switch s, declared := schema[name]; {
+		case hasSchema && !declared:
+			candidates := append(slices.Collect(maps.Keys(fields)), slices.Collect(maps.Keys(schema))...)
+			d.errorf(key, "unknown key %q%s, expected a frontmatter field or one of the params %s",
+				name, suggestKey(name, candidates), strings.Join(slices.Sorted(maps.Keys(schema)), ", "))
+			continue
+		case !hasSchema && b.config.StrictFrontmatter:
+			d.errorf(key, "unknown key %q%s", name, suggestKey(name, slices.Collect(maps.Keys(fields))))
+			continue
+		case hasSchema && !paramMatches(v, s.Type, b.location):
+			d.errorf(value, "param %s must be a %s, got %s", name, s.Type, paramType(v))
+			continue
+		}
+
+		if fm.Params == nil {
+			fm.Params = make(map[string]any)
+		}
+		fm.Params[name] = v
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(schema)) {
+		if _, ok := nodes[name]; !ok && schema[name].Required {
+			d.errorf(root, "missing required param %s", name)
+		}
+	}
+
+	return nodes
+}
+
+// applyFrontmatter validates decoded frontmatter and copies it to pg,
+// reporting problems at the value nodes they come from
+func (b *builder) applyFrontmatter(d *frontmatterDecoder, nodes map[string]*yaml.Node, fm *frontmatter, pg *page) {
+	dates := []struct {
+		name  string
+		value string
+		dst   *time.Time
+	}{
+		{"date", fm.Date, &pg.DateTime},
+		{"updated", fm.Updated, &pg.UpdatedTime},
+		{"expires", fm.Expires, &pg.ExpiresTime},
+	}
+	for _, date := range dates {
+		if date.value == "" {
+			continue
+		}
+		t, err := parseDate(date.value, b.location)
+		if err != nil {
+			d.errorf(nodes[date.name], "invalid %s %q, expected RFC 3339, YYYY-MM-DDTHH:MM:SS or YYYY-MM-DD", date.name, date.value)
+			continue
+		}
+		*date.dst = t
+	}
+
+	if !pg.DateTime.IsZero() && !pg.UpdatedTime.IsZero() && pg.UpdatedTime.Before(pg.DateTime) {
+		d.errorf(nodes["updated"], "updated %s is before date %s", fm.Updated, fm.Date)
+	}
+
+	pg.Title = fm.Title
+	pg.Description = fm.Description
+	pg.Date = fm.Date
+	pg.Template = fm.Template
+	pg.Draft = fm.Draft
+	pg.Tags = fm.Tags
+	pg.Expires = fm.Expires
+	pg.Updated = fm.Updated
+	pg.Summary = fm.Summary
+	pg.Params = fm.Params
+
+	for i, alias := range fm.Aliases {
+		clean, err := cleanAlias(alias)
+		if err != nil {
+			var n *yaml.Node
+			if aliases := nodes["aliases"]; aliases != nil && i < len(aliases.Content) {
+				n = aliases.Content[i]
+			}
+			d.errorf(n, "%v", err)
+			continue
+		}
+		pg.Aliases = append(pg.Aliases, clean)
+	}
+
+	pg.TOCMinDepth, pg.TOCMaxDepth = defaultTOCMinDepth, defaultTOCMaxDepth
+	if fm.TOCMinDepth != 0 {
+		pg.TOCMinDepth = fm.TOCMinDepth
+	}
+	if fm.TOCMaxDepth != 0 {
+		pg.TOCMaxDepth = fm.TOCMaxDepth
+	}
+	if pg.TOCMinDepth < 1 || pg.TOCMaxDepth > 6 || pg.TOCMinDepth > pg.TOCMaxDepth {
+		n := nodes["toc_min_depth"]
+		if n == nil {
+			n = nodes["toc_max_depth"]
+		}
+		d.errorf(n, "invalid toc depths %d to %d, expected heading levels from 1 to 6 with min not above max", pg.TOCMinDepth, pg.TOCMaxDepth)
+	}
+	pg.ShowTOC = fm.TOC
+}
+
+// fields returns the frontmatter fields by key, leaving out the params that
+// collect every other key
+func (fm *frontmatter) fields() map[string]reflect.Value {
+	v := reflect.ValueOf(fm).Elem()
+	fields := make(map[string]reflect.Value)
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name != "" {
+			fields[name] = v.Field(i)
+		}
+	}
+	return fields
+}
+
+// mismatchedNode returns the node under n whose tag does not match the
+// kind of t, or nil if every value has the type t expects. Decoding alone
+// would convert "yes" to true and 5 to "5". Null leaves a field unset, and
+// dates are kept as strings for parseDate.
+func mismatchedNode(n *yaml.Node, t reflect.Type) *yaml.Node {
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null" {
+		return nil
+	}
+
+	var ok bool
+	switch t.Kind() {
+	case reflect.String:
+		ok = n.Kind == yaml.ScalarNode && (n.ShortTag() == "!!str" || n.ShortTag() == "!!timestamp")
+	case reflect.Bool:
+		ok = n.Kind == yaml.ScalarNode && n.ShortTag() == "!!bool"
+	case reflect.Int:
+		ok = n.Kind == yaml.ScalarNode && n.ShortTag() == "!!int"
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return n
+		}
+		for _, elem := range n.Content {
+			if m := mismatchedNode(elem, t.Elem()); m != nil {
+				return m
+			}
+		}
+		return nil
+	default:
+		ok = true
+	}
+	if !ok {
+		return n
+	}
+	return nil
+}
+
+// describeType describes the values a frontmatter field accepts
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int:
+		return "an integer"
+	case reflect.Slice:
+		return "a list of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem()), "a "), "an ") + "s"
+	default:
+		return t.String()
+	}
+}
+
+// describeNode describes the kind of value a YAML node holds
+func describeNode(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.AliasNode:
+		return "an alias"
+	}
+	switch n.ShortTag() {
+	case "!!int":
+		return "an integer"
+	case "!!float":
+		return "a number"
+	case "!!bool":
+		return "a boolean"
+	case "!!null":
+		return "nothing"
+	case "!!timestamp":
+		return "a date"
+	default:
+		return fmt.Sprintf("%q", n.Value)
+	}
+}
+
+// suggestKey returns a hint naming the candidate closest to an unknown key,
+// or nothing if none is close enough to be a likely typo
+func suggestKey(name string, candidates []string) string {
+	slices.Sort(candidates)
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if dist := editDistance(name, c); dist < bestDist {
+			best, bestDist = c, dist
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// splitFrontmatter splits content into its frontmatter and the markdown
+// that follows. The format is nil when content has no frontmatter, and an
+// opening fence without a closing one is an error rather than content.
+func splitFrontmatter(content []byte) (format *frontmatterFormat, raw, body []byte, err error) {
+	first, _, _ := bytes.Cut(content, []byte("\n"))
+	first = bytes.TrimRight(first, " \t")
+
+	for i := range frontmatterFormats {
+		f := &frontmatterFormats[i]
+		if string(first) != f.open {
+			continue
+		}
+
+		// start is where the line after the opening fence begins
+		start := bytes.IndexByte(content, '\n') + 1
+		for lineStart := start; start > 0 && lineStart < len(content); {
+			line, _, more := bytes.Cut(content[lineStart:], []byte("\n"))
+			lineEnd := lineStart + len(line)
+			if string(bytes.TrimRight(line, " \t")) == f.close {
+				body = content[min(lineEnd+1, len(content)):]
+				if f.fenced {
+					return f, content[:lineEnd], body, nil
+				}
+				return f, content[start:lineStart], body, nil
+			}
+			if !more {
+				break
+			}
+			lineStart = lineEnd + 1
+		}
+		return nil, nil, nil, fmt.Errorf("%s frontmatter opened with %q is not closed, expected a line with %q", f.name, f.open, f.close)
+	}
+
+	return nil, nil, content, nil
+}
+
+// yamlErrorLine matches the line number yaml.v3 puts in syntax errors
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// parseYAML parses YAML frontmatter, treating empty frontmatter as an empty
+// mapping
+func parseYAML(raw []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return nil, &syntaxError{line: line, msg: m[2]}
+		}
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}, nil
+	}
+	return doc.Content[0], nil
+}
+
+// tomlKey matches a TOML key = value line up to the start of the value
+var tomlKey = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+|"[^"]*"|'[^']*')\s*=\s*`)
+
+// parseTOML parses TOML frontmatter into a YAML mapping in key order. The
+// TOML parser does not report where keys are, so top-level keys are located
+// by their key = value lines.
+func parseTOML(raw []byte) (*yaml.Node, error) {
+	var values map[string]any
+	md, err := toml.Decode(string(raw), &values)
+	if err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, &syntaxError{line: pe.Position.Line, col: pe.Position.Col, msg: pe.Message}
+		}
+		return nil, err
+	}
+
+	type position struct{ line, keyCol, valueCol int }
+	positions := make(map[string]position)
+	var inTable bool
+	for i, line := range strings.Split(string(raw), "\n") {
+		// Keys after a table header belong to the table, which is itself
+		// a top-level key
+		if header := strings.TrimSpace(line); strings.HasPrefix(header, "[") {
+			inTable = true
+			name, _, _ := strings.Cut(strings.Trim(header, "[] \t"), ".")
+			if col := strings.Index(line, name) + 1; positions[name] == (position{}) {
+				positions[name] = position{i + 1, col, col}
+			}
+			continue
+		}
+		m := tomlKey.FindStringSubmatch(line)
+		if m == nil || inTable {
+			continue
+		}
+		if name := strings.Trim(m[2], `"'`); positions[name] == (position{}) {
+			positions[name] = position{i + 1, len(m[1]) + 1, len(m[0]) + 1}
+		}
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}
+	for _, key := range md.Keys() {
+		if len(key) != 1 {
+			continue
+		}
+		name := key[0]
+
+		v := values[name]
+		if t, ok := v.(time.Time); ok {
+			v = formatTOMLTime(t)
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(v); err != nil {
+			return nil, fmt.Errorf("converting %s: %w", name, err)
+		}
+
+		pos := positions[name]
+		value.Line, value.Column = pos.line, pos.valueCol
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name, Line: pos.line, Column: pos.keyCol},
+			value)
+	}
+	return root, nil
+}
+
+// formatTOMLTime formats a TOML date or time as a string for parseDate.
+// Local dates and date-times have no zone, so they are formatted without one
+// to be read in the site timezone like YAML dates.
+func formatTOMLTime(t time.Time) string {
+	switch t.Location().String() {
+	case "date-local":
+		return t.Format(time.DateOnly)
+	case "datetime-local":
+		return t.Format(localDateTime)
+	case "time-local":
+		return t.Format(time.TimeOnly)
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// parseJSON parses JSON frontmatter into YAML nodes carrying the position of
+// every value
+func parseJSON(raw []byte) (*yaml.Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	root, err := jsonNode(dec, raw)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return root, nil
+		}
+		if err == nil {
+			line, col := offsetPosition(raw, int(dec.InputOffset()))
+			return nil, &syntaxError{line: line, col: col, msg: "unexpected data after the object"}
+		}
+	}
+
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		line, col := offsetPosition(raw, int(se.Offset))
+		return nil, &syntaxError{line: line, col: col, msg: se.Error()}
+	}
+	return nil, err
+}
+
+// jsonNode reads the next JSON value from dec as a YAML node positioned in raw
+func jsonNode(dec *json.Decoder, raw []byte) (*yaml.Node, error) {
+	// The decoder skips whitespace and separators before a token
+	start := int(dec.InputOffset())
+	for start < len(raw) && strings.IndexByte(" \t\r\n,:", raw[start]) >= 0 {
+		start++
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &yaml.Node{Kind: yaml.ScalarNode}
+	n.Line, n.Column = offsetPosition(raw, start)
+
+	switch t := tok.(type) {
+	case json.Delim:
+		n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		if t == '[' {
+			n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		}
+		for dec.More() {
+			child, err := jsonNode(dec, raw)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, child)
+		}
+		// The closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.Tag, n.Value, n.Style = "!!str", t, yaml.DoubleQuotedStyle
+	case json.Number:
+		n.Tag, n.Value = "!!int", t.String()
+		if strings.ContainsAny(n.Value, ".eE") {
+			n.Tag = "!!float"
+		}
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(t)
+	case nil:
+		n.Tag, n.Value = "!!null", "null"
+	}
+	return n, nil
+}
+
+// offsetPosition returns the line and column of a byte offset in raw
+func offsetPosition(raw []byte, offset int) (line, col int) {
+	before := raw[:min(max(offset, 0), len(raw))]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
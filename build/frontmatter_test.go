@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrontmatterTypeErrors(t *testing.T) {
+	b := &builder{
+		config:   &siteConfig{Dirs: dirsConfig{Content: "content"}},
+		location: time.UTC,
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"yaml quoted bool", "---\ndraft: \"yes\"\n---\n", `content/post.md:2:8: draft must be true or false, got "yes"`},
+		{"yaml word bool", "---\ndraft: on\n---\n", `content/post.md:2:8: draft must be true or false, got "on"`},
+		{"yaml quoted true", "---\ntoc: \"true\"\n---\n", `content/post.md:2:6: toc must be true or false, got "true"`},
+		{"yaml numeric title", "---\ntitle: 5\n---\n", "content/post.md:2:8: title must be a string, got an integer"},
+		{"yaml quoted int", "---\ntoc_min_depth: \"2\"\n---\n", `content/post.md:2:16: toc_min_depth must be an integer, got "2"`},
+		{"yaml numeric tag", "---\ntags: [go, 5]\n---\n", "content/post.md:2:12: tags must be a list of strings, got an integer"},
+		{"toml string bool", "+++\ndraft = \"yes\"\n+++\n", `content/post.md:2:9: draft must be true or false, got "yes"`},
+		{"toml numeric title", "+++\ntitle = 5\n+++\n", "content/post.md:2:9: title must be a string, got an integer"},
+		{"toml float depth", "+++\ntoc_max_depth = 2.5\n+++\n", "content/post.md:2:17: toc_max_depth must be an integer, got a number"},
+		{"json string bool", "{\n  \"draft\": \"yes\"\n}\n", `content/post.md:2:12: draft must be true or false, got "yes"`},
+		{"json numeric title", "{\n  \"title\": 5\n}\n", "content/post.md:2:12: title must be a string, got an integer"},
+		{"json numeric tag", "{\n  \"tags\": [\"go\", 5]\n}\n", "content/post.md:2:18: tags must be a list of strings, got an integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := b.parseFrontmatter("content/post.md", []byte(tt.content))
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("got error %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrontmatterTypes(t *testing.T) {
+	b := &builder{
+		config:   &siteConfig{Dirs: dirsConfig{Content: "content"}},
+		location: time.UTC,
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"yaml", "---\ntitle: hello\ndate: 2024-01-02\ndraft: true\ntags: [go]\ntoc_min_depth: 2\nsummary:\n---\n"},
+		{"toml", "+++\ntitle = \"hello\"\ndate = 2024-01-02\ndraft = true\ntags = [\"go\"]\ntoc_min_depth = 2\n+++\n"},
+		{"json", "{\n  \"title\": \"hello\",\n  \"date\": \"2024-01-02\",\n  \"draft\": true,\n  \"tags\": [\"go\"],\n  \"toc_min_depth\": 2\n}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pg, _, err := b.parseFrontmatter("content/post.md", []byte(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pg.Title != "hello" || !pg.Draft || strings.Join(pg.Tags, ",") != "go" || pg.TOCMinDepth != 2 {
+				t.Errorf("got %+v", pg)
+			}
+			if want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC); !pg.DateTime.Equal(want) {
+				t.Errorf("got date %v, want %v", pg.DateTime, want)
+			}
+		})
+	}
+}
@@ -5,19 +5,48 @@ import (
 	"bytes"
 	"cmp"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
-// relPath returns the path relative to contentDir
-func relPath(path string) string {
-	return strings.TrimPrefix(path, contentDir+string(filepath.Separator))
+// relPath returns the path relative to the content directory
+func (b *builder) relPath(path string) string {
+	return strings.TrimPrefix(path, b.config.Dirs.Content+string(filepath.Separator))
+}
+
+// forEachParallel calls fn for each index in [0, n) using at most jobs
+// concurrent workers and returns all errors joined in index order
+func forEachParallel(n, jobs int, fn func(i int) error) error {
+	jobs = max(1, min(jobs, n))
+	errs := make([]error, n)
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Go(func() {
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		})
+	}
+
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
 // copyFile copies a file from src to dst
@@ -51,8 +80,20 @@ func escapeXML(s string) string {
 	return buf.String()
 }
 
-// parseDate parses a YYYY-MM-DD date string in the given timezone
+// localDateTime is the layout of a timestamp without an offset, such as a
+// TOML local date-time
+const localDateTime = "2006-01-02T15:04:05"
+
+// parseDate parses an RFC 3339 timestamp, keeping its explicit offset, a
+// timestamp without an offset in the given timezone, or a YYYY-MM-DD date at
+// midnight in the given timezone
 func parseDate(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(localDateTime, s, loc); err == nil {
+		return t, nil
+	}
 	t, err := time.ParseInLocation(time.DateOnly, s, loc)
 	if err != nil {
 		return time.Time{}, fmt.Errorf("parsing date %q: %w", s, err)
@@ -60,6 +101,15 @@ func parseDate(s string, loc *time.Location) (time.Time, error) {
 	return t, nil
 }
 
+// displayDate formats t as a YYYY-MM-DD date in the site timezone, or returns
+// an empty string for the zero time
+func (b *builder) displayDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(b.location).Format(time.DateOnly)
+}
+
 // formatDateRSS formats time for RSS feeds (RFC1123Z)
 func formatDateRSS(t time.Time) string {
 	return t.Format(time.RFC1123Z)
@@ -71,8 +121,8 @@ func formatDateAtom(t time.Time) string {
 }
 
 // loadJournal reads and parses the journal file
-func loadJournal(loc *time.Location) ([]journal, error) {
-	file, err := os.Open(journalFile)
+func loadJournal(path string, loc *time.Location) ([]journal, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("opening journal: %w", err)
 	}
@@ -118,3 +168,85 @@ func loadJournal(loc *time.Location) ([]journal, error) {
 
 	return entries, nil
 }
+
+// trackingParams are query parameters stripped from journal URLs
+var trackingParams = []string{
+	"ref", "source", "medium", "campaign", "fbclid", "gclid", "_ga", "_gl", "__readwiseLocation",
+}
+
+// cleanJournalURL removes tracking query parameters from a journal entry,
+// preserving the order of the remaining parameters. The rest of the entry is
+// kept exactly as given, without re-encoding, and any scheme is accepted;
+// only entries that would not fit on one journal line are rejected.
+func cleanJournalURL(raw string) (string, error) {
+	entry := strings.TrimSpace(raw)
+	if entry == "" || strings.ContainsFunc(entry, unicode.IsSpace) {
+		return "", fmt.Errorf("entry %q must be a single non-empty url", raw)
+	}
+
+	rest, fragment, hasFragment := strings.Cut(entry, "#")
+	base, query, _ := strings.Cut(rest, "?")
+
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(param, "=")
+		if strings.HasPrefix(key, "utm_") || slices.Contains(trackingParams, key) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	entry = base
+	if len(kept) > 0 {
+		entry += "?" + strings.Join(kept, "&")
+	}
+	if hasFragment {
+		entry += "#" + fragment
+	}
+	return entry, nil
+}
+
+// appendJournal cleans a URL and appends it to the journal file with the given
+// timestamp, returning the cleaned URL and whether it was added (false if it
+// was already present)
+func appendJournal(path, raw string, now time.Time) (string, bool, error) {
+	entry, err := cleanJournalURL(raw)
+	if err != nil {
+		return "", false, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", false, fmt.Errorf("reading journal: %w", err)
+	}
+
+	for line := range strings.Lines(string(content)) {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == entry {
+			return entry, false, nil
+		}
+	}
+
+	var sb strings.Builder
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("%d %s\n", now.Unix(), entry))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return "", false, fmt.Errorf("opening journal: %w", err)
+	}
+	if _, err := f.WriteString(sb.String()); err != nil {
+		f.Close()
+		return "", false, fmt.Errorf("writing journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", false, fmt.Errorf("writing journal: %w", err)
+	}
+
+	return entry, true, nil
+}
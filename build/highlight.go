@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Highlight classes wrapped around code tokens. Styling them through a
+// stylesheet rather than inline styles keeps the CSP's style-src 'self'.
+const (
+	hlKeyword  = "hl-keyword"
+	hlLiteral  = "hl-literal"
+	hlString   = "hl-string"
+	hlNumber   = "hl-number"
+	hlComment  = "hl-comment"
+	hlKey      = "hl-key"
+	hlVariable = "hl-variable"
+)
+
+// highlightStyles are the rules of the highlight stylesheet, in output order
+var highlightStyles = []struct {
+	class        string
+	declarations []string
+}{
+	{hlKeyword, []string{"color: #8250df;"}},
+	{hlLiteral, []string{"color: #0550ae;"}},
+	{hlString, []string{"color: #0a3069;"}},
+	{hlNumber, []string{"color: #0550ae;"}},
+	{hlComment, []string{"color: #6e7781;", "font-style: italic;"}},
+	{hlKey, []string{"color: #116329;"}},
+	{hlVariable, []string{"color: #953800;"}},
+}
+
+// language describes how to tokenize code in one language
+type language struct {
+	keywords     map[string]bool
+	literals     map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+	// rawQuotes are quotes whose strings have no escape sequences
+	rawQuotes string
+	// foldCase matches keywords case-insensitively
+	foldCase bool
+	// keySuffix marks an identifier or string followed by it as a key
+	keySuffix string
+	// identChars are allowed inside identifiers besides letters and digits
+	identChars string
+	// variables highlights shell style $NAME and ${NAME} expansions
+	variables bool
+}
+
+// words returns a set of the space-separated words in s
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+// languages maps code block info strings to their language
+var languages = func() map[string]*language {
+	golang := &language{
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var`),
+		literals:     words(`true false nil iota`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+		rawQuotes:    "`",
+		identChars:   "_",
+	}
+	shell := &language{
+		keywords: words(`if then else elif fi for while until do done case esac in function return
+			export local readonly set unset exit source`),
+		literals:     words(`true false`),
+		lineComments: []string{"#"},
+		quotes:       "\"",
+		rawQuotes:    "'",
+		identChars:   "_-",
+		variables:    true,
+	}
+	yaml := &language{
+		literals:     words(`true false null yes no on off`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		keySuffix:    ":",
+		identChars:   "_-.",
+	}
+	json := &language{
+		literals:   words(`true false null`),
+		quotes:     "\"",
+		keySuffix:  ":",
+		identChars: "_",
+	}
+	hcl := &language{
+		keywords:     words(`resource data variable output locals module provider terraform for in if for_each count dynamic`),
+		literals:     words(`true false null`),
+		lineComments: []string{"#", "//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"",
+		keySuffix:    "=",
+		identChars:   "_-",
+	}
+	sql := &language{
+		keywords: words(`select from where and or not insert into values update set delete create table
+			drop alter add index primary key foreign references join inner left right outer full on
+			as group by order having limit offset distinct union all case when then else end is in
+			like between exists with returning begin commit rollback default unique constraint view
+			asc desc`),
+		literals:     words(`true false null`),
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "'\"",
+		foldCase:     true,
+		identChars:   "_",
+	}
+
+	return map[string]*language{
+		"go":        golang,
+		"golang":    golang,
+		"sh":        shell,
+		"shell":     shell,
+		"bash":      shell,
+		"zsh":       shell,
+		"console":   shell,
+		"yaml":      yaml,
+		"yml":       yaml,
+		"json":      json,
+		"hcl":       hcl,
+		"terraform": hcl,
+		"tf":        hcl,
+		"sql":       sql,
+	}
+}()
+
+// renderCodeBlock highlights fenced code blocks in a supported language,
+// leaving other code blocks to the default renderer
+func renderCodeBlock(w io.Writer, block *ast.CodeBlock) (ast.WalkStatus, bool) {
+	name, _, _ := strings.Cut(strings.TrimSpace(string(block.Info)), " ")
+	lang, ok := languages[strings.ToLower(name)]
+	if !ok {
+		return ast.GoToNext, false
+	}
+
+	fmt.Fprintf(w, `<pre><code class="language-%s">`, template.HTMLEscapeString(name))
+	io.WriteString(w, highlight(string(block.Literal), lang))
+	io.WriteString(w, "</code></pre>\n")
+	return ast.GoToNext, true
+}
+
+// highlight returns code as escaped HTML with tokens wrapped in class spans
+func highlight(code string, lang *language) string {
+	var sb strings.Builder
+	span := func(class, text string) {
+		fmt.Fprintf(&sb, `<span class="%s">%s</span>`, class, template.HTMLEscapeString(text))
+	}
+
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		r, size := utf8.DecodeRuneInString(rest)
+
+		if open := lang.blockComment[0]; open != "" && strings.HasPrefix(rest, open) {
+			end := strings.Index(rest[len(open):], lang.blockComment[1])
+			n := len(rest)
+			if end >= 0 {
+				n = len(open) + end + len(lang.blockComment[1])
+			}
+			span(hlComment, rest[:n])
+			i += n
+			continue
+		}
+
+		if lang.isLineComment(code, i) {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			span(hlComment, rest[:n])
+			i += n
+			continue
+		}
+
+		if strings.ContainsRune(lang.quotes+lang.rawQuotes, r) {
+			n := scanString(rest, r, strings.ContainsRune(lang.rawQuotes, r))
+			class := hlString
+			if lang.isKey(rest[n:], true) {
+				class = hlKey
+			}
+			span(class, rest[:n])
+			i += n
+			continue
+		}
+
+		if lang.variables && r == '$' {
+			if n := scanVariable(rest); n > 1 {
+				span(hlVariable, rest[:n])
+				i += n
+				continue
+			}
+		}
+
+		if unicode.IsDigit(r) && !lang.followsIdent(code, i) {
+			n := scanWhile(rest, func(r rune) bool {
+				return unicode.IsDigit(r) || unicode.IsLetter(r) || r == '.' || r == '_'
+			})
+			span(hlNumber, rest[:n])
+			i += n
+			continue
+		}
+
+		if unicode.IsLetter(r) || r == '_' {
+			n := scanWhile(rest, lang.isIdent)
+			word := rest[:n]
+			lookup := word
+			if lang.foldCase {
+				lookup = strings.ToLower(word)
+			}
+
+			switch {
+			case lang.isKey(rest[n:], false):
+				span(hlKey, word)
+			case lang.keywords[lookup]:
+				span(hlKeyword, word)
+			case lang.literals[lookup]:
+				span(hlLiteral, word)
+			default:
+				sb.WriteString(template.HTMLEscapeString(word))
+			}
+			i += n
+			continue
+		}
+
+		sb.WriteString(template.HTMLEscapeString(rest[:size]))
+		i += size
+	}
+
+	return sb.String()
+}
+
+// isLineComment reports whether a line comment starts at code[i]. A # only
+// starts a comment at the start of a word so that shell and YAML values
+// containing one are left alone.
+func (l *language) isLineComment(code string, i int) bool {
+	for _, marker := range l.lineComments {
+		if !strings.HasPrefix(code[i:], marker) {
+			continue
+		}
+		if marker != "#" || i == 0 {
+			return true
+		}
+		prev, _ := utf8.DecodeLastRuneInString(code[:i])
+		if unicode.IsSpace(prev) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKey reports whether rest, the code following a token, marks it as a key.
+// Unquoted keys must be followed by whitespace after the suffix so values
+// such as URLs are not mistaken for keys.
+func (l *language) isKey(rest string, quoted bool) bool {
+	if l.keySuffix == "" {
+		return false
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	after, ok := strings.CutPrefix(rest, l.keySuffix)
+	if !ok || strings.HasPrefix(after, "=") {
+		return false
+	}
+	if quoted || after == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(after)
+	return unicode.IsSpace(r)
+}
+
+// isIdent reports whether r can continue an identifier
+func (l *language) isIdent(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(l.identChars, r)
+}
+
+// followsIdent reports whether code[i] continues an identifier, such as the
+// digits in x2
+func (l *language) followsIdent(code string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	prev, _ := utf8.DecodeLastRuneInString(code[:i])
+	return l.isIdent(prev)
+}
+
+// scanString returns the length of the string literal opened by quote at the
+// start of s, or the rest of s if it is never closed
+func scanString(s string, quote rune, raw bool) int {
+	for i := utf8.RuneLen(quote); i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\\' && !raw:
+			i += size
+			if i < len(s) {
+				_, next := utf8.DecodeRuneInString(s[i:])
+				i += next
+			}
+		case r == quote:
+			return i + size
+		case r == '\n' && !raw && quote != '"':
+			// Unterminated character literals stop at the end of the line
+			return i
+		default:
+			i += size
+		}
+	}
+	return len(s)
+}
+
+// scanVariable returns the length of the shell variable expansion at the
+// start of s, or 1 if the $ does not start one
+func scanVariable(s string) int {
+	if strings.HasPrefix(s, "${") {
+		if end := strings.IndexByte(s, '}'); end > 0 {
+			return end + 1
+		}
+		return 1
+	}
+	return 1 + scanWhile(s[1:], func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	})
+}
+
+// scanWhile returns the length of the prefix of s whose runes satisfy ok
+func scanWhile(s string, ok func(rune) bool) int {
+	for i, r := range s {
+		if !ok(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// writeHighlightCSS writes the stylesheet for the highlight classes
+func writeHighlightCSS(w io.Writer) error {
+	for i, s := range highlightStyles {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, ".%s {\n  %s\n}\n", s.class, strings.Join(s.declarations, "\n  ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// imageExtensions are the extensions of images the pipeline resizes
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+// imageVariant is one generated size of a responsive image
+type imageVariant struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+// responsiveImage holds the variants generated for a content image, ordered
+// by width with the original size last
+type responsiveImage struct {
+	Width    int
+	Height   int
+	Variants []imageVariant
+}
+
+// src returns the URL of the largest variant for browsers without srcset
+func (r *responsiveImage) src() string {
+	return r.Variants[len(r.Variants)-1].URL
+}
+
+// srcset returns the variants as a srcset attribute value
+func (r *responsiveImage) srcset() string {
+	parts := make([]string, len(r.Variants))
+	for i, v := range r.Variants {
+		parts[i] = fmt.Sprintf("%s %dw", v.URL, v.Width)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// imageSet processes each content image once, however many pages reference
+// it. It is safe for concurrent use.
+type imageSet struct {
+	mu     sync.Mutex
+	images map[string]*imageResult
+}
+
+// imageResult is the outcome of processing one image
+type imageResult struct {
+	once  sync.Once
+	image *responsiveImage
+	err   error
+}
+
+// processImages checks that every image under doc has alt text and
+// generates the variants of local ones
+func (b *builder) processImages(doc ast.Node) error {
+	var errs []error
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		img, ok := node.(*ast.Image)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		dest := string(img.Destination)
+
+		if plainText(img) == "" {
+			if b.config.Images.RequireAlt {
+				errs = append(errs, fmt.Errorf("image %s has no alt text", dest))
+			} else {
+				slog.Warn("image has no alt text", "src", dest)
+			}
+		}
+
+		if isSafeURL(dest) {
+			if _, err := b.responsiveImage(dest); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return ast.GoToNext
+	})
+	return errors.Join(errs...)
+}
+
+// responsiveImage returns the variants of the image at dest, generating them
+// on first use. It returns nil for images the pipeline leaves alone, such as
+// remote images and formats it cannot decode.
+func (b *builder) responsiveImage(dest string) (*responsiveImage, error) {
+	if len(b.config.Images.Widths) == 0 {
+		return nil, nil
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" || !strings.HasPrefix(u.Path, "/") {
+		return nil, nil
+	}
+	if !imageExtensions[strings.ToLower(path.Ext(u.Path))] {
+		return nil, nil
+	}
+	urlPath := path.Clean(u.Path)
+
+	b.images.mu.Lock()
+	result, ok := b.images.images[urlPath]
+	if !ok {
+		result = &imageResult{}
+		b.images.images[urlPath] = result
+	}
+	b.images.mu.Unlock()
+
+	result.once.Do(func() {
+		result.image, result.err = b.generateVariants(urlPath)
+		if result.err != nil {
+			result.err = fmt.Errorf("processing image %s: %w", urlPath, result.err)
+		}
+	})
+	return result.image, result.err
+}
+
+// findImage returns the source file published at urlPath, looking in the
+// static directory and then in page bundles under the content directory
+func (b *builder) findImage(urlPath string) (string, error) {
+	rel := filepath.FromSlash(strings.TrimPrefix(urlPath, "/"))
+	for _, dir := range []string{b.config.Dirs.Static, b.config.Dirs.Content} {
+		p := filepath.Join(dir, rel)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no file in %s or %s: %w", b.config.Dirs.Static, b.config.Dirs.Content, fs.ErrNotExist)
+}
+
+// generateVariants writes fingerprinted copies of the image at urlPath
+// resized to each configured width narrower than the original, plus one at
+// the original size
+func (b *builder) generateVariants(urlPath string) (*responsiveImage, error) {
+	srcPath, err := b.findImage(urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceKey, err := hashFiles(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", srcPath, err)
+	}
+
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", srcPath, err)
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, fmt.Errorf("%s has no pixels", srcPath)
+	}
+
+	// Resizing would drop every frame of an animation but the first
+	var animated bool
+	if format == "gif" {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		g, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", srcPath, err)
+		}
+		animated = len(g.Image) > 1
+	}
+
+	img := &responsiveImage{Width: cfg.Width, Height: cfg.Height}
+	var widths []int
+	if !animated {
+		for _, w := range b.config.Images.Widths {
+			if w < cfg.Width {
+				widths = append(widths, w)
+			}
+		}
+	}
+	widths = append(widths, cfg.Width)
+
+	// The source is decoded only if some variant is not cached
+	var decoded image.Image
+	ext := path.Ext(urlPath)
+	stem := strings.TrimSuffix(urlPath, ext)
+
+	for _, w := range widths {
+		h := max(1, (cfg.Height*w+cfg.Width/2)/cfg.Width)
+		quality := strconv.Itoa(b.config.Images.Quality)
+		fingerprint := hashKey(sourceKey, strconv.Itoa(w), quality)[:10]
+
+		v := imageVariant{
+			URL:    fmt.Sprintf("%s-%d.%s%s", stem, w, fingerprint, ext),
+			Width:  w,
+			Height: h,
+		}
+		img.Variants = append(img.Variants, v)
+
+		outputPath := filepath.Join(b.outDir, filepath.FromSlash(v.URL))
+		if b.cache.current(outputPath, b.outputKey(nil, "image", sourceKey, strconv.Itoa(w), quality)) {
+			continue
+		}
+
+		// The original size is the source file itself
+		if w == cfg.Width {
+			if err := copyFile(srcPath, outputPath); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if decoded == nil {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			decoded, _, err = image.Decode(f)
+			if err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", srcPath, err)
+			}
+		}
+
+		if err := b.writeVariant(outputPath, format, resize(decoded, w, h)); err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+// writeVariant encodes img in format to outputPath
+func (b *builder) writeVariant(outputPath, format string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", outputPath, err)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(out, img, &jpeg.Options{Quality: b.config.Images.Quality})
+	case "gif":
+		err = gif.Encode(out, img, nil)
+	default:
+		err = png.Encode(out, img)
+	}
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("encoding %s: %w", outputPath, err)
+	}
+	return out.Close()
+}
+
+// boxWeight is the share of one source pixel in an output pixel
+type boxWeight struct {
+	index  int
+	weight float64
+}
+
+// boxWeights returns, for each of n output pixels spanning size source
+// pixels, the source pixels it covers weighted by how much of each it covers
+func boxWeights(size, n int) [][]boxWeight {
+	scale := float64(size) / float64(n)
+	weights := make([][]boxWeight, n)
+	for i := range weights {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		for j := int(start); j < size && float64(j) < end; j++ {
+			if cover := min(end, float64(j+1)) - max(start, float64(j)); cover > 0 {
+				weights[i] = append(weights[i], boxWeight{j, cover / scale})
+			}
+		}
+	}
+	return weights
+}
+
+// resize scales src down to w by h pixels, averaging the source pixels each
+// output pixel covers. Averaging premultiplied colors keeps transparent
+// pixels from bleeding their color into their neighbors.
+func resize(src image.Image, w, h int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	columns := boxWeights(bounds.Dx(), w)
+	row := make([]float64, 4*w)
+
+	for y, rows := range boxWeights(bounds.Dy(), h) {
+		clear(row)
+		for _, r := range rows {
+			srcRow := rgba.Pix[r.index*rgba.Stride:]
+			for x, cols := range columns {
+				for _, c := range cols {
+					weight := r.weight * c.weight
+					p := srcRow[4*c.index : 4*c.index+4]
+					for i := range 4 {
+						row[4*x+i] += weight * float64(p[i])
+					}
+				}
+			}
+		}
+
+		out := dst.Pix[y*dst.Stride:]
+		for i, v := range row {
+			out[i] = uint8(min(v+0.5, 255))
+		}
+	}
+
+	return dst
+}
+
+// renderImage writes an image inline, leaving figures to renderFigure
+func (b *builder) renderImage(w io.Writer, img *ast.Image, entering bool) (ast.WalkStatus, bool) {
+	if entering {
+		b.writeImage(w, img, true)
+	}
+	// The alt text is written with the tag rather than by the default renderer
+	return ast.SkipChildren, true
+}
+
+// renderFigure writes a paragraph holding only an image as a figure, with
+// the image title as its caption
+func (b *builder) renderFigure(w io.Writer, img *ast.Image, entering bool) (ast.WalkStatus, bool) {
+	if !entering {
+		return ast.GoToNext, true
+	}
+
+	io.WriteString(w, "<figure class=\"figure\">\n")
+	b.writeImage(w, img, false)
+	if title := strings.TrimSpace(string(img.Title)); title != "" {
+		fmt.Fprintf(w, "\n<figcaption>%s</figcaption>", template.HTMLEscapeString(title))
+	}
+	io.WriteString(w, "\n</figure>\n")
+	return ast.SkipChildren, true
+}
+
+// writeImage writes an <img> tag that loads lazily, with local images made
+// responsive using every generated variant in srcset and their original
+// dimensions to reserve their space. The title is left out when it is
+// written as a caption instead.
+func (b *builder) writeImage(w io.Writer, img *ast.Image, withTitle bool) {
+	dest := string(img.Destination)
+	io.WriteString(w, "<img")
+
+	// Block potentially dangerous URI schemes, leaving only the alt text
+	if isSafeURL(dest) {
+		resp, err := b.responsiveImage(dest)
+		if err != nil {
+			// Images in page and shortcode content fail the build when
+			// collected, so this is only reached by frontmatter summaries
+			slog.Warn("serving image as is", "src", dest, "error", err)
+		}
+		if resp != nil {
+			fmt.Fprintf(w, ` src="%s" srcset="%s" sizes="%s" width="%d" height="%d"`,
+				template.HTMLEscapeString(resp.src()),
+				template.HTMLEscapeString(resp.srcset()),
+				template.HTMLEscapeString(b.config.Images.Sizes),
+				resp.Width, resp.Height)
+		} else {
+			fmt.Fprintf(w, ` src="%s"`, template.HTMLEscapeString(dest))
+		}
+	}
+
+	fmt.Fprintf(w, ` alt="%s" loading="lazy" decoding="async"`, template.HTMLEscapeString(plainText(img)))
+	if withTitle && img.Title != nil {
+		fmt.Fprintf(w, ` title="%s"`, template.HTMLEscapeString(string(img.Title)))
+	}
+	io.WriteString(w, " />")
+}
+
+// standaloneImage returns the image a paragraph holds when it holds nothing
+// else but whitespace
+func standaloneImage(p *ast.Paragraph) *ast.Image {
+	var img *ast.Image
+	for _, child := range p.Children {
+		switch n := child.(type) {
+		case *ast.Image:
+			if img != nil {
+				return nil
+			}
+			img = n
+		case *ast.Text:
+			if len(bytes.TrimSpace(n.Literal)) != 0 {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+	return img
+}
@@ -1,19 +1,17 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
 )
 
 func main() {
-	b, err := newBuilder()
-	if err != nil {
-		slog.Error("initialization failed", "error", err)
-		os.Exit(1)
-	}
-
-	if err := b.build(); err != nil {
-		slog.Error("build failed", "error", err)
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		slog.Error("command failed", "error", err)
 		os.Exit(1)
 	}
 }
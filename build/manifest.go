@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"text/template/parse"
+	"time"
+)
+
+// manifestFile is the build cache manifest name inside the cache directory
+const manifestFile = "manifest.json"
+
+// Site data sections that outputs can depend on
+const (
+	sectionJournal = "journal"
+	sectionBlog    = "blog"
+	sectionPages   = "pages"
+)
+
+// siteSections maps siteData identifiers referenced by templates to the
+// section of site data they read
+var siteSections = map[string]string{
+	"JournalEntries":        sectionJournal,
+	"FeedJournalEntries":    sectionJournal,
+	"LatestJournalDateAtom": sectionJournal,
+	"BlogPosts":             sectionBlog,
+	"FeedBlogPosts":         sectionBlog,
+	"LatestBlogDateAtom":    sectionBlog,
+	"Tags":                  sectionBlog,
+	"FeedTagPosts":          sectionBlog,
+	"Pages":                 sectionPages,
+}
+
+// manifest records the input key each output was last built from
+type manifest struct {
+	Output  string            `json:"output"`
+	Outputs map[string]string `json:"outputs"`
+}
+
+// buildCache decides which outputs need rebuilding by comparing input keys
+// against the manifest of the previous successful build, reusing unchanged
+// outputs from the previous output directory. It is safe for concurrent use.
+type buildCache struct {
+	mu        sync.Mutex
+	path      string
+	dir       string
+	prev      manifest
+	next      manifest
+	rebuilt   []string
+	unchanged int
+}
+
+// openBuildCache loads the manifest at path for the given output directory,
+// for a build writing into dir. The manifest is removed until the build
+// succeeds so a failed build never leaves it describing outputs that were
+// partially rewritten. An empty path disables caching and every output is
+// rebuilt.
+func openBuildCache(path, output, dir string) (*buildCache, error) {
+	c := &buildCache{
+		path: path,
+		dir:  dir,
+		next: manifest{Output: output, Outputs: make(map[string]string)},
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	var prev manifest
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	if prev.Output == output {
+		c.prev = prev
+	}
+
+	if err := os.Remove(path); err != nil {
+		return nil, fmt.Errorf("removing manifest: %w", err)
+	}
+
+	return c, nil
+}
+
+// current records key for the output at path and reports whether the
+// previous build's file was built from the same inputs, in which case it has
+// been carried over to path and does not need to be written
+func (c *buildCache) current(path, key string) bool {
+	rel, err := filepath.Rel(c.dir, path)
+	if err != nil {
+		rel = path
+	}
+
+	c.mu.Lock()
+	prevKey := c.prev.Outputs[rel]
+	c.next.Outputs[rel] = key
+	c.mu.Unlock()
+
+	reused := prevKey == key && linkOrCopy(filepath.Join(c.next.Output, rel), path) == nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reused {
+		c.unchanged++
+	} else {
+		c.rebuilt = append(c.rebuilt, rel)
+	}
+	return reused
+}
+
+// incremental reports whether a previous manifest was available
+func (c *buildCache) incremental() bool {
+	return c.prev.Outputs != nil
+}
+
+// save writes the manifest for the outputs recorded in this build
+func (c *buildCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", c.path, err)
+	}
+
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// hashKey combines parts into a single hex digest
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashJSON returns the digest of v's JSON encoding
+func hashJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return hashKey(string(data)), nil
+}
+
+// hashFiles returns the digest of the named files' contents
+func hashFiles(paths ...string) (string, error) {
+	parts := make([]string, 0, len(paths))
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(data))
+	}
+	return hashKey(parts...), nil
+}
+
+// generatorKey identifies the running binary so code changes invalidate the cache
+func generatorKey() string {
+	exe, err := os.Executable()
+	if err == nil {
+		if key, err := hashFiles(exe); err == nil {
+			return key
+		}
+	}
+	// Without a stable identity nothing can be trusted, so force a full rebuild
+	return hashKey(time.Now().String())
+}
+
+// templateSections returns the site data sections referenced by the parse trees
+func templateSections(trees ...*parse.Tree) []string {
+	seen := make(map[string]bool)
+	for _, t := range trees {
+		if t != nil {
+			walkTemplateIdents(t.Root, func(ident string) {
+				if s, ok := siteSections[ident]; ok {
+					seen[s] = true
+				}
+			})
+		}
+	}
+
+	sections := make([]string, 0, len(seen))
+	for s := range seen {
+		sections = append(sections, s)
+	}
+	slices.Sort(sections)
+	return sections
+}
+
+// walkTemplateIdents calls fn for every field and method name referenced under node
+func walkTemplateIdents(node parse.Node, fn func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkTemplateIdents(c, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplateIdents(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			walkTemplateIdents(c, fn)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			walkTemplateIdents(a, fn)
+		}
+	case *parse.FieldNode:
+		for _, id := range n.Ident {
+			fn(id)
+		}
+	case *parse.VariableNode:
+		for _, id := range n.Ident {
+			fn(id)
+		}
+	case *parse.ChainNode:
+		walkTemplateIdents(n.Node, fn)
+		for _, id := range n.Field {
+			fn(id)
+		}
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkTemplateIdents(n.Pipe, fn)
+	}
+}
+
+// walkBranch walks the pipeline and both lists of an if, range or with action
+func walkBranch(n *parse.BranchNode, fn func(string)) {
+	walkTemplateIdents(n.Pipe, fn)
+	walkTemplateIdents(n.List, fn)
+	walkTemplateIdents(n.ElseList, fn)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// outputMarker is written to the root of every build so later builds know the
+// output directory holds a built site and is theirs to replace
+const outputMarker = ".site-build"
+
+// checkReplaceable returns an error if output is a non-empty directory that
+// does not hold a previous build, so a mistyped output directory is never
+// deleted along with whatever it held
+func checkReplaceable(output string) error {
+	entries, err := os.ReadDir(output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading output directory: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(output, outputMarker)); err == nil {
+		return nil
+	}
+	return fmt.Errorf("output directory %s is not empty and holds no previous build (no %s file), remove it or choose another output directory", output, outputMarker)
+}
+
+// writeOutputMarker marks dir as holding a built site
+func writeOutputMarker(dir string) error {
+	content := "This directory was written by the site build and is replaced by every build.\n"
+	if err := os.WriteFile(filepath.Join(dir, outputMarker), []byte(content), 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", outputMarker, err)
+	}
+	return nil
+}
+
+// checkPageOutputs returns an error for every output file written by more
+// than one page, such as a content page at the URL of a generated tag page,
+// which would otherwise silently replace one page with the other
+func checkPageOutputs(pages []pageInfo) error {
+	var errs []error
+	claimed := make(map[string]pageInfo, len(pages))
+	for _, info := range pages {
+		output := filepath.Clean(info.outputPath)
+		if other, ok := claimed[output]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s are both published at %s", other.source(), info.source(), info.page.URL))
+			continue
+		}
+		claimed[output] = info
+	}
+	return errors.Join(errs...)
+}
+
+// newStagingDir creates an empty directory next to output for a build to
+// write into, so it can later be renamed over output on the same filesystem
+func newStagingDir(output string) (string, error) {
+	parent := filepath.Dir(output)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("creating directory %s: %w", parent, err)
+	}
+
+	dir, err := os.MkdirTemp(parent, "."+filepath.Base(output)+".staging-")
+	if err != nil {
+		return "", fmt.Errorf("creating staging directory: %w", err)
+	}
+
+	// MkdirTemp uses 0700, but the output directory is served to others
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("setting staging directory permissions: %w", err)
+	}
+
+	return dir, nil
+}
+
+// staleFiles returns the files under output, relative to it, that the build
+// in staging no longer produces
+func staleFiles(output, staging string) ([]string, error) {
+	var stale []string
+
+	err := filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == output {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(output, path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(filepath.Join(staging, rel)); errors.Is(err, fs.ErrNotExist) {
+			stale = append(stale, rel)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+
+	return stale, err
+}
+
+// swapDir replaces output with staging. The previous output is moved aside
+// rather than modified, so readers never see a partially written site. The
+// swap takes two renames and is not atomic: between them output briefly does
+// not exist, and a server reading it then finds no files.
+func swapDir(staging, output string) error {
+	old := filepath.Join(filepath.Dir(output), "."+filepath.Base(output)+".old")
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("removing %s: %w", old, err)
+	}
+
+	if err := os.Rename(output, old); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("moving aside %s: %w", output, err)
+	}
+
+	if err := os.Rename(staging, output); err != nil {
+		// Put the previous output back so a failed swap changes nothing
+		if rerr := os.Rename(old, output); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+			err = errors.Join(err, rerr)
+		}
+		return fmt.Errorf("moving %s into place: %w", staging, err)
+	}
+
+	if err := os.RemoveAll(old); err != nil {
+		return fmt.Errorf("removing previous output: %w", err)
+	}
+	return nil
+}
+
+// linkOrCopy hard links src to dst, preserving its modification time, and
+// falls back to copying when linking is not possible
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", dst, err)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
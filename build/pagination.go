@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pager describes one page of a paginated listing
+type pager struct {
+	Number  int // 1-based
+	Total   int
+	URL     string
+	PrevURL string // empty on the first page
+	NextURL string // empty on the last page
+
+	// Items on this page; only the field for the paginated listing is set
+	JournalEntries []journal
+	BlogPosts      []blogPost
+}
+
+// pageBounds splits n items into pages of at most size items, returning the
+// [start, end) bounds of each. A size of zero puts everything on one page, and
+// there is always at least one page so empty listings still render.
+func pageBounds(n, size int) [][2]int {
+	if size <= 0 || n <= size {
+		return [][2]int{{0, n}}
+	}
+
+	var bounds [][2]int
+	for start := 0; start < n; start += size {
+		bounds = append(bounds, [2]int{start, min(start+size, n)})
+	}
+	return bounds
+}
+
+// pageURL returns the URL of page number n of a listing at base
+func pageURL(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	return fmt.Sprintf("%s/page/%d", base, n)
+}
+
+// paginate expands the journal and blog index into one page per chunk of entries
+func (b *builder) paginate(pages []pageInfo) []pageInfo {
+	var out []pageInfo
+	for _, info := range pages {
+		switch info.pathType {
+		case pathJournal:
+			entries := b.site.JournalEntries
+			out = append(out, b.paginatePage(info, len(entries), b.config.Pagination.Journal, func(p *pager, start, end int) {
+				p.JournalEntries = entries[start:end]
+			})...)
+		case pathBlogIndex:
+			posts := b.site.BlogPosts
+			out = append(out, b.paginatePage(info, len(posts), b.config.Pagination.Blog, func(p *pager, start, end int) {
+				p.BlogPosts = posts[start:end]
+			})...)
+		default:
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// paginatePage returns a copy of info for each page of n items, using fill to
+// set the items on each page. The first page keeps the original URL and
+// output path; later pages are written under <url>/page/<n>, which
+// checkPageOutputs rejects if a content page is already published there.
+func (b *builder) paginatePage(info pageInfo, n, size int, fill func(p *pager, start, end int)) []pageInfo {
+	bounds := pageBounds(n, size)
+	base := info.page.URL
+
+	pages := make([]pageInfo, 0, len(bounds))
+	for i, bd := range bounds {
+		p := &pager{
+			Number: i + 1,
+			Total:  len(bounds),
+			URL:    pageURL(base, i+1),
+		}
+		if p.Number > 1 {
+			p.PrevURL = pageURL(base, p.Number-1)
+		}
+		if p.Number < p.Total {
+			p.NextURL = pageURL(base, p.Number+1)
+		}
+		fill(p, bd[0], bd[1])
+
+		pg := *info.page
+		pg.URL = p.URL
+
+		pi := info
+		pi.page = &pg
+		pi.pager = p
+		if p.Number > 1 {
+			pi.outputPath = filepath.Join(b.outDir, filepath.FromSlash(strings.TrimPrefix(p.URL, "/"))+".html")
+		}
+
+		pages = append(pages, pi)
+	}
+
+	return pages
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPaginatedPageCollidesWithContentPage(t *testing.T) {
+	b := &builder{
+		config: &siteConfig{Pagination: paginationConfig{Blog: 1}},
+		site:   &siteData{BlogPosts: []blogPost{{Title: "one"}, {Title: "two"}}},
+		outDir: "public",
+	}
+	pages := []pageInfo{
+		{
+			page:       &page{URL: "/blog"},
+			path:       "content/blog/index.md",
+			outputPath: filepath.Join("public", "blog.html"),
+			pathType:   pathBlogIndex,
+		},
+		{
+			page:       &page{URL: "/blog/page/2"},
+			path:       "content/blog/page/2.md",
+			outputPath: filepath.Join("public", "blog", "page", "2.html"),
+			pathType:   pathBlogPost,
+		},
+	}
+
+	err := checkPageOutputs(b.paginate(pages))
+	if err == nil {
+		t.Fatal("expected an error for a content page at a paginated URL")
+	}
+	for _, want := range []string{"page 2 of content/blog/index.md", "content/blog/page/2.md"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestPaginatedPagesDoNotCollide(t *testing.T) {
+	b := &builder{
+		config: &siteConfig{Pagination: paginationConfig{Blog: 1}},
+		site:   &siteData{BlogPosts: []blogPost{{Title: "one"}, {Title: "two"}, {Title: "three"}}},
+		outDir: "public",
+	}
+	pages := []pageInfo{{
+		page:       &page{URL: "/blog"},
+		path:       "content/blog/index.md",
+		outputPath: filepath.Join("public", "blog.html"),
+		pathType:   pathBlogIndex,
+	}}
+
+	paginated := b.paginate(pages)
+	if len(paginated) != 3 {
+		t.Fatalf("got %d pages, want 3", len(paginated))
+	}
+	if err := checkPageOutputs(paginated); err != nil {
+		t.Fatal(err)
+	}
+}
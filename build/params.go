@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Types a param schema can require of a custom frontmatter param
+const (
+	paramString = "string"
+	paramBool   = "bool"
+	paramInt    = "int"
+	paramFloat  = "float"
+	paramDate   = "date"
+	paramList   = "list"
+	paramMap    = "map"
+)
+
+// paramTypes are the valid param schema types
+var paramTypes = []string{paramString, paramBool, paramInt, paramFloat, paramDate, paramList, paramMap}
+
+// rootSection is the section of pages directly in the content directory
+const rootSection = "/"
+
+// contentSection returns the section a content file belongs to: its
+// top-level directory under the content directory, or rootSection
+func (b *builder) contentSection(path string) string {
+	section, _, ok := strings.Cut(filepath.ToSlash(b.relPath(path)), "/")
+	if !ok {
+		return rootSection
+	}
+	return section
+}
+
+// paramMatches reports whether a decoded frontmatter value has the schema
+// type typ. Dates may also be strings in a format parseDate accepts, and
+// floats may be written as integers.
+func paramMatches(v any, typ string, loc *time.Location) bool {
+	got := paramType(v)
+	switch {
+	case got == typ:
+		return true
+	case typ == paramFloat:
+		return got == paramInt
+	case typ == paramDate && got == paramString:
+		_, err := parseDate(v.(string), loc)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// paramType returns the schema type of a decoded frontmatter value
+func paramType(v any) string {
+	switch v := v.(type) {
+	case string:
+		return paramString
+	case bool:
+		return paramBool
+	case int, int64, uint64:
+		return paramInt
+	case float64:
+		return paramFloat
+	case time.Time:
+		return paramDate
+	case []any:
+		return paramList
+	case map[string]any:
+		return paramMap
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Redirect files written to the output root for hosts that serve real redirects
+const (
+	redirectsFile     = "_redirects"
+	redirectsJSONFile = "redirects.json"
+)
+
+// redirect maps an alias URL to the page that replaced it
+type redirect struct {
+	from string
+	page *page
+}
+
+// cleanAlias normalizes an alias to the extensionless form determineURL
+// produces, rejecting values that cannot be written as a stub page
+func cleanAlias(alias string) (string, error) {
+	if !strings.HasPrefix(alias, "/") {
+		return "", fmt.Errorf("alias %q must be an absolute path", alias)
+	}
+
+	clean := strings.TrimSuffix(path.Clean(alias), ".html")
+	if clean == "/" || clean == "" {
+		return "", fmt.Errorf("alias %q must not be the site root", alias)
+	}
+	if strings.ContainsAny(clean, "?#") {
+		return "", fmt.Errorf("alias %q must not contain a query or fragment", alias)
+	}
+
+	return clean, nil
+}
+
+// collectRedirects gathers the aliases of every page, sorted by alias. An
+// alias that matches a page URL or is claimed by more than one page is an
+// error.
+func collectRedirects(pages []pageInfo) ([]redirect, error) {
+	urls := make(map[string]bool, len(pages))
+	for _, info := range pages {
+		urls[info.page.URL] = true
+	}
+
+	var errs []error
+	claimed := make(map[string]*page)
+	var redirects []redirect
+
+	for _, info := range pages {
+		for _, alias := range info.page.Aliases {
+			if urls[alias] {
+				errs = append(errs, fmt.Errorf("alias %s of %s collides with an existing page", alias, info.path))
+				continue
+			}
+			if other, ok := claimed[alias]; ok {
+				if other != info.page {
+					errs = append(errs, fmt.Errorf("alias %s is claimed by both %s and %s", alias, other.URL, info.page.URL))
+				}
+				continue
+			}
+			claimed[alias] = info.page
+			redirects = append(redirects, redirect{from: alias, page: info.page})
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(redirects, func(a, b redirect) int {
+		return cmp.Compare(a.from, b.from)
+	})
+	return redirects, nil
+}
+
+// writeRedirects writes a meta refresh stub at each alias path, plus a
+// Netlify style _redirects file and a JSON map so a CDN can serve 301s
+func (b *builder) writeRedirects(redirects []redirect) error {
+	tmpl := b.templates[tmplAlias]
+	inputs := b.templateInputs[tmplAlias]
+
+	for _, r := range redirects {
+		outputPath := filepath.Join(b.outDir, filepath.FromSlash(r.from)+".html")
+
+		// Static files are copied first, so anything here is a real file
+		if _, err := os.Stat(outputPath); err == nil {
+			return fmt.Errorf("alias %s of %s collides with a static file", r.from, r.page.URL)
+		}
+
+		key := b.outputKey(inputs.sections, inputs.key, r.from, r.page.URL, r.page.Title)
+		if b.cache.current(outputPath, key) {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", outputPath, err)
+		}
+
+		data := templateData{
+			Page: r.page,
+			Site: b.site,
+		}
+
+		if err := writeTemplate(outputPath, tmpl, data); err != nil {
+			return fmt.Errorf("rendering alias %s: %w", r.from, err)
+		}
+	}
+
+	var sb strings.Builder
+	targets := make(map[string]string, len(redirects))
+	for _, r := range redirects {
+		sb.WriteString(fmt.Sprintf("%s %s 301\n", r.from, r.page.URL))
+		targets[r.from] = r.page.URL
+	}
+
+	data, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding redirects: %w", err)
+	}
+
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{redirectsFile, []byte(sb.String())},
+		{redirectsJSONFile, append(data, '\n')},
+	}
+	for _, f := range files {
+		outputPath := filepath.Join(b.outDir, f.name)
+		if b.cache.current(outputPath, b.outputKey(nil, string(f.content))) {
+			continue
+		}
+		if err := os.WriteFile(outputPath, f.content, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+	}
+
+	return nil
+}
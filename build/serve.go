@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// devServer serves the most recent successful build and rebuilds on source changes
+type devServer struct {
+	opts    cliOptions
+	root    string
+	current atomic.Pointer[string]
+}
+
+// fileState is the part of a file's metadata used to detect changes
+type fileState struct {
+	modTime int64
+	size    int64
+}
+
+// serve builds the site into a temporary directory, serves it on addr and
+// polls the sources every interval, rebuilding when anything changes
+func serve(ctx context.Context, opts cliOptions, addr string, interval time.Duration) error {
+	root, err := os.MkdirTemp("", "site-serve-")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	s := &devServer{opts: opts, root: root}
+	if err := s.rebuild(); err != nil {
+		return err
+	}
+
+	go s.watch(ctx, interval)
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	slog.Info("serving site", "url", "http://"+addr)
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("serving: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	return nil
+}
+
+// rebuild builds the site into a fresh directory and swaps it in on success,
+// so requests never see a partially written build
+func (s *devServer) rebuild() error {
+	dir, err := os.MkdirTemp(s.root, "build-")
+	if err != nil {
+		return fmt.Errorf("creating build directory: %w", err)
+	}
+
+	if err := s.buildInto(dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	if old := s.current.Swap(&dir); old != nil {
+		os.RemoveAll(*old)
+	}
+	return nil
+}
+
+// buildInto runs a full build with the output directory set to dir
+func (s *devServer) buildInto(dir string) error {
+	cfg, err := s.opts.config()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	cfg.Dirs.Output = dir
+
+	opts, err := s.opts.buildOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	b, err := newBuilder(cfg, opts)
+	if err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+
+	if err := b.build(); err != nil {
+		return fmt.Errorf("build failed: %w", err)
+	}
+	return nil
+}
+
+// watch polls the site sources and rebuilds whenever they change
+func (s *devServer) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prev := s.snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := s.snapshot()
+		if maps.Equal(prev, cur) {
+			continue
+		}
+		prev = cur
+
+		slog.Info("change detected, rebuilding")
+		if err := s.rebuild(); err != nil {
+			slog.Error("rebuild failed, serving previous build", "error", err)
+		}
+	}
+}
+
+// snapshot records the state of every source file that affects the build
+func (s *devServer) snapshot() map[string]fileState {
+	configPath := filepath.Join(s.opts.source, configFile)
+	state := make(map[string]fileState)
+	recordFile(state, configPath)
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return state
+	}
+
+	recordFile(state, cfg.Dirs.Journal)
+	for _, dir := range []string{cfg.Dirs.Content, cfg.Dirs.Templates, cfg.Dirs.Static} {
+		filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			recordFile(state, p)
+			return nil
+		})
+	}
+
+	return state
+}
+
+// recordFile adds the state of the file at p, if it exists
+func recordFile(state map[string]fileState, p string) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	state[p] = fileState{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+// ServeHTTP serves files from the current build, resolving extensionless URLs
+// the same way determineURL produces them
+func (s *devServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dir := *s.current.Load()
+
+	p, ok := resolveURLPath(dir, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// resolveURLPath maps a request path to a file in root, trying the exact
+// file, then the .html page, then a directory index
+func resolveURLPath(root, urlPath string) (string, bool) {
+	clean := path.Clean("/" + urlPath)
+	if clean == "/" {
+		clean = "/index"
+	}
+	rel := filepath.FromSlash(strings.TrimPrefix(clean, "/"))
+
+	candidates := []string{
+		rel,
+		rel + ".html",
+		filepath.Join(rel, "index.html"),
+	}
+
+	for _, c := range candidates {
+		p := filepath.Join(root, c)
+		info, err := os.Stat(p)
+		if err == nil && info.Mode().IsRegular() {
+			return p, true
+		}
+	}
+
+	return "", false
+}
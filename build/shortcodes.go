@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// shortcodesDir is the templates subdirectory holding shortcode templates
+const shortcodesDir = "shortcodes"
+
+// shortcodeTag matches an opening, closing or self-closing shortcode tag such
+// as {{< figure src="a.png" >}}, {{< /figure >}} or {{< figure src="a.png" />}}
+var shortcodeTag = regexp.MustCompile(`\{\{<\s*(/)?\s*([A-Za-z][\w-]*)((?:\s+[A-Za-z][\w-]*="(?:[^"\\]|\\.)*")*)\s*(/)?\s*>\}\}`)
+
+// shortcodeArg matches a single key="value" shortcode argument
+var shortcodeArg = regexp.MustCompile(`([A-Za-z][\w-]*)="((?:[^"\\]|\\.)*)"`)
+
+// shortcodePlaceholder matches the placeholders standing in for rendered
+// shortcodes while the surrounding markdown is parsed
+var shortcodePlaceholder = regexp.MustCompile(`SHORTCODE\d+PLACEHOLDER`)
+
+// shortcodeTemplates holds the templates rendering a shortcode into HTML and
+// into the .md twin
+type shortcodeTemplates struct {
+	html     *template.Template
+	markdown *texttemplate.Template // nil to use the inner markdown as is
+}
+
+// shortcodeData is passed to shortcode templates
+type shortcodeData struct {
+	Args    map[string]string
+	Inner   string        // inner markdown with nested shortcodes rendered as markdown
+	Content template.HTML // inner markdown rendered to HTML
+	base    string
+}
+
+// URL returns the named argument, resolving relative paths against the page
+// bundle they belong to
+func (d shortcodeData) URL(name string) string {
+	return resolveRelativeURL(d.Args[name], d.base)
+}
+
+// shortcodeCall is a use of a shortcode in markdown content
+type shortcodeCall struct {
+	name  string
+	tmpls shortcodeTemplates
+	data  shortcodeData
+	// inner is the expanded inner content
+	inner expandedMarkdown
+	// source is the shortcode as written, from its opening to closing tag
+	source []byte
+}
+
+// shortcodeNode is a shortcode in a parsed document. Its one child is the
+// document parsed from its inner content, so the headings and words there
+// belong to the page like any others.
+type shortcodeNode struct {
+	ast.Container
+	call *shortcodeCall
+	// html is the rendered shortcode, set by renderShortcodes
+	html []byte
+}
+
+// expandedMarkdown is markdown whose shortcodes have been expanded
+type expandedMarkdown struct {
+	// body has a placeholder in place of each shortcode
+	body []byte
+	// twin has each shortcode rendered as markdown for the .md twin
+	twin []byte
+	// calls holds the shortcode each placeholder stands for
+	calls map[string]*shortcodeCall
+}
+
+// parse parses content, a part of the expanded body, swapping each shortcode
+// placeholder for a node holding the shortcode's parsed inner content. Every
+// heading in the document, including those in shortcodes, gets an id unique
+// within it.
+func (e expandedMarkdown) parse(content []byte, base string) ast.Node {
+	explicit := make(map[*ast.Heading]bool)
+	doc := e.parseFragment(content, base, explicit)
+	assignHeadingIDs(doc, explicit)
+	return doc
+}
+
+// parseFragment parses content and the inner content of its shortcodes,
+// recording headings with explicit ids in explicit
+func (e expandedMarkdown) parseFragment(content []byte, base string, explicit map[*ast.Heading]bool) ast.Node {
+	doc := parseFragment(content, base, explicit)
+	insertShortcodes(doc, e.calls, func(call *shortcodeCall) ast.Node {
+		return call.inner.parseFragment(call.inner.body, base, explicit)
+	})
+	return doc
+}
+
+// loadShortcodes loads the HTML and optional markdown templates of every
+// shortcode in the shortcodes templates directory
+func (b *builder) loadShortcodes() error {
+	dir := filepath.Join(b.config.Dirs.Templates, shortcodesDir)
+	paths, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	mdFuncs := texttemplate.FuncMap{
+		"blockquote": blockquote,
+	}
+
+	b.shortcodes = make(map[string]shortcodeTemplates, len(paths))
+	inputs := slices.Clone(paths)
+
+	for _, htmlPath := range paths {
+		name := strings.TrimSuffix(filepath.Base(htmlPath), ".html")
+
+		var tmpls shortcodeTemplates
+		tmpls.html, err = template.ParseFiles(htmlPath)
+		if err != nil {
+			return fmt.Errorf("parsing shortcode %s: %w", name, err)
+		}
+
+		mdPath := filepath.Join(dir, name+".md")
+		if _, err := os.Stat(mdPath); err == nil {
+			tmpls.markdown, err = texttemplate.New(filepath.Base(mdPath)).Funcs(mdFuncs).ParseFiles(mdPath)
+			if err != nil {
+				return fmt.Errorf("parsing shortcode %s: %w", name, err)
+			}
+			inputs = append(inputs, mdPath)
+		}
+
+		b.shortcodes[name] = tmpls
+	}
+
+	slices.Sort(inputs)
+	b.shortcodesKey, err = hashFiles(inputs...)
+	if err != nil {
+		return fmt.Errorf("hashing shortcodes: %w", err)
+	}
+	return nil
+}
+
+// expandShortcodes replaces the shortcodes in markdown content with
+// placeholders and renders them as markdown for the .md twin, resolving
+// relative URL arguments against base. Shortcodes in code are left as written.
+func (b *builder) expandShortcodes(content []byte, base string) (expandedMarkdown, error) {
+	e := expandedMarkdown{calls: make(map[string]*shortcodeCall)}
+	var body, twin bytes.Buffer
+	code := codeRanges(content)
+
+	pos := 0
+	for {
+		m := shortcodeTag.FindSubmatchIndex(content[pos:])
+		if m == nil {
+			break
+		}
+		for i := range m {
+			if m[i] >= 0 {
+				m[i] += pos
+			}
+		}
+
+		if inRanges(code, m[0]) {
+			body.Write(content[pos:m[1]])
+			twin.Write(content[pos:m[1]])
+			pos = m[1]
+			continue
+		}
+
+		name := string(content[m[4]:m[5]])
+		if m[2] >= 0 {
+			return e, fmt.Errorf("closing shortcode %s without an opening tag", name)
+		}
+
+		args, err := parseShortcodeArgs(string(content[m[6]:m[7]]))
+		if err != nil {
+			return e, fmt.Errorf("shortcode %s: %w", name, err)
+		}
+
+		// Shortcodes without the self-closing slash wrap inner content
+		var inner []byte
+		end := m[1]
+		if m[8] < 0 {
+			innerEnd, closeEnd, ok := findClosingShortcode(content, m[1], name, code)
+			if !ok {
+				return e, fmt.Errorf("shortcode %s is not closed, expected {{< /%s >}} or a self-closing tag", name, name)
+			}
+			inner = content[m[1]:innerEnd]
+			end = closeEnd
+		}
+
+		call, md, err := b.expandShortcode(name, args, inner, base)
+		if err != nil {
+			return e, err
+		}
+
+		call.source = content[m[0]:end]
+		placeholder := fmt.Sprintf("SHORTCODE%dPLACEHOLDER", len(e.calls))
+		e.calls[placeholder] = call
+
+		body.Write(content[pos:m[0]])
+		body.WriteString(placeholder)
+		twin.Write(content[pos:m[0]])
+		twin.Write(md)
+
+		pos = end
+	}
+
+	body.Write(content[pos:])
+	twin.Write(content[pos:])
+	e.body = body.Bytes()
+	e.twin = twin.Bytes()
+	return e, nil
+}
+
+// findClosingShortcode finds the tag closing the shortcode name whose inner
+// content starts at pos, skipping nested shortcodes of the same name and tags
+// in the code ranges. It returns where the inner content ends and where the
+// closing tag ends.
+func findClosingShortcode(content []byte, pos int, name string, code [][2]int) (innerEnd, closeEnd int, ok bool) {
+	depth := 1
+	for _, m := range shortcodeTag.FindAllSubmatchIndex(content[pos:], -1) {
+		if string(content[pos+m[4]:pos+m[5]]) != name || inRanges(code, pos+m[0]) {
+			continue
+		}
+		switch {
+		case m[2] >= 0:
+			depth--
+		case m[8] < 0:
+			depth++
+		}
+		if depth == 0 {
+			return pos + m[0], pos + m[1], true
+		}
+	}
+	return 0, 0, false
+}
+
+// parseShortcodeArgs parses key="value" arguments, unescaping values
+func parseShortcodeArgs(s string) (map[string]string, error) {
+	args := make(map[string]string)
+	for _, m := range shortcodeArg.FindAllStringSubmatch(s, -1) {
+		value, err := strconv.Unquote(`"` + m[2] + `"`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", m[1], err)
+		}
+		if _, ok := args[m[1]]; ok {
+			return nil, fmt.Errorf("duplicate argument %s", m[1])
+		}
+		args[m[1]] = value
+	}
+	return args, nil
+}
+
+// expandShortcode expands the inner content of a shortcode and renders the
+// shortcode to markdown for the .md twin. Its HTML is rendered by
+// renderShortcodes once the page is parsed.
+func (b *builder) expandShortcode(name string, args map[string]string, inner []byte, base string) (*shortcodeCall, []byte, error) {
+	tmpls, ok := b.shortcodes[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown shortcode %q, expected a template in %s", name, filepath.Join(b.config.Dirs.Templates, shortcodesDir))
+	}
+
+	// Inner content may use shortcodes of its own
+	expanded, err := b.expandShortcodes(inner, base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	call := &shortcodeCall{
+		name:  name,
+		tmpls: tmpls,
+		data: shortcodeData{
+			Args:  args,
+			Inner: strings.TrimSpace(string(expanded.twin)),
+			base:  base,
+		},
+		inner: expanded,
+	}
+
+	if tmpls.markdown == nil {
+		return call, []byte(call.data.Inner), nil
+	}
+
+	var mdBuf bytes.Buffer
+	if err := tmpls.markdown.Execute(&mdBuf, call.data); err != nil {
+		return nil, nil, fmt.Errorf("rendering shortcode %s as markdown: %w", name, err)
+	}
+	return call, bytes.TrimRight(mdBuf.Bytes(), "\n"), nil
+}
+
+// renderShortcodes renders the HTML of every shortcode under node, innermost
+// first so each shortcode's content includes the shortcodes nested in it
+func (b *builder) renderShortcodes(node ast.Node) error {
+	var errs []error
+	ast.WalkFunc(node, func(node ast.Node, entering bool) ast.WalkStatus {
+		n, ok := node.(*shortcodeNode)
+		if !ok || entering {
+			return ast.GoToNext
+		}
+
+		data := n.call.data
+		data.Content = template.HTML(b.renderHTML(n.Children[0]))
+
+		var buf bytes.Buffer
+		if err := n.call.tmpls.html.Execute(&buf, data); err != nil {
+			errs = append(errs, fmt.Errorf("rendering shortcode %s: %w", n.call.name, err))
+			return ast.GoToNext
+		}
+		n.html = buf.Bytes()
+		return ast.GoToNext
+	})
+	return errors.Join(errs...)
+}
+
+// insertShortcodes replaces the shortcode placeholders under doc with nodes
+// holding the inner content parse returns for each call. A shortcode alone in
+// a paragraph replaces the paragraph so block elements are not wrapped in <p>.
+func insertShortcodes(doc ast.Node, calls map[string]*shortcodeCall, parse func(*shortcodeCall) ast.Node) {
+	if len(calls) == 0 {
+		return
+	}
+
+	newNode := func(call *shortcodeCall) ast.Node {
+		n := &shortcodeNode{call: call}
+		ast.AppendChild(n, parse(call))
+		return n
+	}
+
+	var texts []*ast.Text
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Text:
+			if shortcodePlaceholder.Match(n.Literal) {
+				texts = append(texts, n)
+			}
+		case *ast.Code, *ast.CodeBlock:
+			// Shortcodes in fenced blocks and spans are never expanded, but
+			// those in indented code blocks are and must be put back as written
+			leaf := n.AsLeaf()
+			leaf.Literal = shortcodePlaceholder.ReplaceAllFunc(leaf.Literal, func(placeholder []byte) []byte {
+				if call, ok := calls[string(placeholder)]; ok {
+					return call.source
+				}
+				return placeholder
+			})
+		}
+		return ast.GoToNext
+	})
+
+	for _, t := range texts {
+		if p, ok := t.Parent.(*ast.Paragraph); ok && len(p.Children) == 1 {
+			if call, ok := calls[strings.TrimSpace(string(t.Literal))]; ok {
+				replaceNode(p, newNode(call))
+				continue
+			}
+		}
+
+		var nodes []ast.Node
+		pos := 0
+		for _, m := range shortcodePlaceholder.FindAllIndex(t.Literal, -1) {
+			call, ok := calls[string(t.Literal[m[0]:m[1]])]
+			if !ok {
+				continue
+			}
+			if m[0] > pos {
+				nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: t.Literal[pos:m[0]]}})
+			}
+			nodes = append(nodes, newNode(call))
+			pos = m[1]
+		}
+		if pos < len(t.Literal) {
+			nodes = append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: t.Literal[pos:]}})
+		}
+		replaceNode(t, nodes...)
+	}
+}
+
+// replaceNode replaces n in its parent's children with nodes
+func replaceNode(n ast.Node, nodes ...ast.Node) {
+	parent := n.GetParent()
+	var children []ast.Node
+	for _, c := range parent.GetChildren() {
+		if c != n {
+			children = append(children, c)
+			continue
+		}
+		for _, r := range nodes {
+			r.SetParent(parent)
+			children = append(children, r)
+		}
+	}
+	parent.SetChildren(children)
+}
+
+// blockquote prefixes every line of s with "> " for markdown templates
+func blockquote(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// tagSymbols are the words symbols in tag names are spelled as in slugs, so
+// tags such as C, C++ and C# get distinct URLs
+var tagSymbols = map[rune]string{
+	'+': "plus",
+	'#': "sharp",
+	'&': "and",
+	'@': "at",
+}
+
+// tagSlug converts a tag name to the lowercase, hyphenated form used in URLs
+func tagSlug(name string) string {
+	var sb strings.Builder
+	hyphen := false
+	word := func(s string) {
+		if hyphen && sb.Len() > 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteString(s)
+		hyphen = false
+	}
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word(string(r))
+		case tagSymbols[r] != "":
+			hyphen = true
+			word(tagSymbols[r])
+			hyphen = true
+		default:
+			hyphen = true
+		}
+	}
+	return sb.String()
+}
+
+// validateTags checks that every tag has a usable slug
+func validateTags(tags []string) error {
+	for _, name := range tags {
+		if tagSlug(name) == "" {
+			return fmt.Errorf("tag %q must contain a letter or digit", name)
+		}
+	}
+	return nil
+}
+
+// buildTags groups blog posts by tag, keeping posts in their existing order.
+// Tags whose names differ only in case are merged, using the first name seen.
+// Other names sharing a slug are an error rather than silently merged.
+func buildTags(posts []blogPost) ([]tag, error) {
+	bySlug := make(map[string]int)
+	var tags []tag
+
+	for _, post := range posts {
+		seen := make(map[string]bool)
+		for _, name := range post.Tags {
+			slug := tagSlug(name)
+			i, ok := bySlug[slug]
+			if !ok {
+				i = len(tags)
+				bySlug[slug] = i
+				tags = append(tags, tag{Name: name, Slug: slug})
+			} else if !strings.EqualFold(tags[i].Name, name) {
+				return nil, fmt.Errorf("tag %q of post %s has the same URL /%s/%s as tag %q, use one name for both",
+					name, post.Slug, pathTagsDir, slug, tags[i].Name)
+			}
+
+			if seen[slug] {
+				continue
+			}
+			seen[slug] = true
+			tags[i].Posts = append(tags[i].Posts, post)
+		}
+	}
+
+	slices.SortFunc(tags, func(a, b tag) int {
+		return cmp.Compare(a.Slug, b.Slug)
+	})
+
+	return tags, nil
+}
+
+// tagPages returns the generated tag index and per-tag listing pages
+func (b *builder) tagPages() []pageInfo {
+	if len(b.site.Tags) == 0 {
+		return nil
+	}
+
+	// Tags only drafts use are marked draft like the posts, keeping them out
+	// of the sitemap of preview builds
+	indexDraft := true
+	for i := range b.site.Tags {
+		indexDraft = indexDraft && b.site.Tags[i].draft()
+	}
+
+	indexURL := "/" + pathTagsDir
+	pages := []pageInfo{{
+		page: &page{
+			Title:       pathTagsDir,
+			Description: b.config.Title + " tags",
+			URL:         indexURL,
+			Slug:        pathTagsDir,
+			Draft:       indexDraft,
+		},
+		path:         indexURL,
+		outputPath:   filepath.Join(b.outDir, pathTagsDir+".html"),
+		templateName: tmplTags,
+		pathType:     pathTagIndex,
+	}}
+
+	for i := range b.site.Tags {
+		t := &b.site.Tags[i]
+		pages = append(pages, pageInfo{
+			page: &page{
+				Title:       t.Name,
+				Description: fmt.Sprintf("%s posts tagged %s", b.config.Title, t.Name),
+				URL:         t.URL(),
+				Slug:        t.Slug,
+				Draft:       t.draft(),
+			},
+			path:         t.URL(),
+			outputPath:   filepath.Join(b.outDir, pathTagsDir, t.Slug+".html"),
+			templateName: tmplTag,
+			pathType:     pathTag,
+			tag:          t,
+		})
+	}
+
+	return pages
+}
@@ -8,13 +8,14 @@ import (
 	"path/filepath"
 	"strings"
 	texttemplate "text/template"
+	"text/template/parse"
 
 	"gopkg.in/yaml.v3"
 )
 
 // loadTemplates loads all HTML templates
 func (b *builder) loadTemplates() error {
-	basePath := filepath.Join(templatesDir, "base.html")
+	basePath := filepath.Join(b.config.Dirs.Templates, "base.html")
 
 	pageTemplates := []string{
 		tmplHome + ".html",
@@ -22,17 +23,52 @@ func (b *builder) loadTemplates() error {
 		tmplBlogPost + ".html",
 		tmplBlogIndex + ".html",
 		tmplJournal + ".html",
+		tmplTags + ".html",
+		tmplTag + ".html",
+	}
+
+	funcMap := template.FuncMap{
+		"tagURL": func(name string) string {
+			return "/" + pathTagsDir + "/" + tagSlug(name)
+		},
+		"date": b.displayDate,
 	}
 
 	for _, name := range pageTemplates {
-		tmplPath := filepath.Join(templatesDir, name)
-		tmpl, err := template.ParseFiles(basePath, tmplPath)
+		tmplPath := filepath.Join(b.config.Dirs.Templates, name)
+		tmpl, err := template.New(filepath.Base(basePath)).Funcs(funcMap).ParseFiles(basePath, tmplPath)
 		if err != nil {
 			return fmt.Errorf("parsing template %s: %w", name, err)
 		}
-		b.templates[strings.TrimSuffix(name, ".html")] = tmpl
+
+		key, err := hashFiles(basePath, tmplPath)
+		if err != nil {
+			return fmt.Errorf("hashing template %s: %w", name, err)
+		}
+
+		var trees []*parse.Tree
+		for _, t := range tmpl.Templates() {
+			trees = append(trees, t.Tree)
+		}
+
+		name = strings.TrimSuffix(name, ".html")
+		b.templates[name] = tmpl
+		b.templateInputs[name] = templateInfo{key: key, sections: templateSections(trees...)}
 	}
 
+	// Alias redirect stubs are standalone documents without the base layout
+	aliasPath := filepath.Join(b.config.Dirs.Templates, tmplAlias+".html")
+	tmpl, err := template.ParseFiles(aliasPath)
+	if err != nil {
+		return fmt.Errorf("parsing template %s: %w", tmplAlias, err)
+	}
+	key, err := hashFiles(aliasPath)
+	if err != nil {
+		return fmt.Errorf("hashing template %s: %w", tmplAlias, err)
+	}
+	b.templates[tmplAlias] = tmpl
+	b.templateInputs[tmplAlias] = templateInfo{key: key, sections: templateSections(tmpl.Tree)}
+
 	return nil
 }
 
@@ -47,47 +83,105 @@ func (b *builder) loadFeedTemplates() error {
 		"feeds/journal.atom",
 		"feeds/blog.xml",
 		"feeds/blog.atom",
+		"feeds/tag.xml",
+		"feeds/tag.atom",
+		"sitemap.xml",
 	}
 
 	for _, name := range feedTemplates {
-		tmplPath := filepath.Join(templatesDir, name)
+		tmplPath := filepath.Join(b.config.Dirs.Templates, name)
 		tmpl, err := texttemplate.New(filepath.Base(name)).Funcs(funcMap).ParseFiles(tmplPath)
 		if err != nil {
 			return fmt.Errorf("parsing feed template %s: %w", name, err)
 		}
+
+		key, err := hashFiles(tmplPath)
+		if err != nil {
+			return fmt.Errorf("hashing feed template %s: %w", name, err)
+		}
+
+		var trees []*parse.Tree
+		for _, t := range tmpl.Templates() {
+			trees = append(trees, t.Tree)
+		}
+
 		b.feedTemplates[name] = tmpl
+		b.templateInputs[name] = templateInfo{key: key, sections: templateSections(trees...)}
 	}
 
 	return nil
 }
 
-// renderPages renders all collected pages
+// renderPages renders all collected pages concurrently
 func (b *builder) renderPages(pages []pageInfo) error {
-	for _, info := range pages {
-		if err := os.MkdirAll(filepath.Dir(info.outputPath), 0755); err != nil {
-			return fmt.Errorf("creating directory for %s: %w", info.outputPath, err)
-		}
+	return forEachParallel(len(pages), b.opts.jobs, func(i int) error {
+		return b.renderPage(pages[i])
+	})
+}
 
-		tmpl, ok := b.templates[info.templateName]
-		if !ok {
-			if info.page.Template != "" {
-				return fmt.Errorf("template %q not found for %s", info.templateName, info.path)
-			}
-			tmpl = b.templates[tmplPage]
+// renderPage renders a single page and its markdown version
+func (b *builder) renderPage(info pageInfo) error {
+	if err := os.MkdirAll(filepath.Dir(info.outputPath), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", info.outputPath, err)
+	}
+
+	name := info.templateName
+	tmpl, ok := b.templates[name]
+	if !ok {
+		if info.page.Template != "" {
+			return fmt.Errorf("template %q not found for %s", info.templateName, info.path)
 		}
+		name = tmplPage
+		tmpl = b.templates[name]
+	}
 
+	inputs := b.templateInputs[name]
+	sections := append(pageSections(info.pathType), inputs.sections...)
+	// The rendered content also depends on files outside the markdown, such
+	// as the generated variants of its images
+	key := b.outputKey(sections, inputs.key, b.shortcodesKey, info.path, string(info.page.MarkdownSource), string(info.page.Content))
+
+	if !b.cache.current(info.outputPath, key) {
 		data := templateData{
-			Page: info.page,
-			Site: b.site,
+			Page:  info.page,
+			Site:  b.site,
+			Tag:   info.tag,
+			Pager: info.pager,
 		}
 
 		if err := writeTemplate(info.outputPath, tmpl, data); err != nil {
 			return fmt.Errorf("rendering %s: %w", info.path, err)
 		}
+	}
+
+	// Write markdown version of the page
+	if err := b.writeMarkdownPage(info); err != nil {
+		return fmt.Errorf("writing markdown for %s: %w", info.path, err)
+	}
+
+	if err := b.copyAssets(info); err != nil {
+		return fmt.Errorf("copying bundle files for %s: %w", info.path, err)
+	}
+
+	return nil
+}
+
+// copyAssets copies a page bundle's files to the same relative paths in the
+// output, next to the rendered page
+func (b *builder) copyAssets(info pageInfo) error {
+	for _, path := range info.assets {
+		outputPath := filepath.Join(b.outDir, b.relPath(path))
+
+		key, err := hashFiles(path)
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", path, err)
+		}
+		if b.cache.current(outputPath, key) {
+			continue
+		}
 
-		// Write markdown version of the page
-		if err := b.writeMarkdownPage(info); err != nil {
-			return fmt.Errorf("writing markdown for %s: %w", info.path, err)
+		if err := copyFile(path, outputPath); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -98,16 +192,25 @@ func (b *builder) writeMarkdownPage(info pageInfo) error {
 	// Determine markdown output path (same as HTML but with .md extension)
 	mdOutputPath := strings.TrimSuffix(info.outputPath, ".html") + ".md"
 
+	key := b.outputKey(pageSections(info.pathType), "markdown", b.shortcodesKey, info.path, string(info.page.MarkdownSource))
+	if b.cache.current(mdOutputPath, key) {
+		return nil
+	}
+
 	var mdContent []byte
 
 	switch info.pathType {
 	case pathJournal:
-		mdContent = b.generateJournalMarkdown(info.page)
+		mdContent = b.generateJournalMarkdown(info.page, info.pager)
 	case pathBlogIndex:
-		mdContent = b.generateBlogIndexMarkdown(info.page)
+		mdContent = b.generateBlogIndexMarkdown(info.page, info.pager)
+	case pathTagIndex:
+		mdContent = b.generateTagIndexMarkdown(info.page)
+	case pathTag:
+		mdContent = b.generateTagMarkdown(info.page, info.tag)
 	default:
 		// For regular pages, use the original markdown source
-		mdContent = info.page.MarkdownSource
+		mdContent = markdownTwin(info.page)
 	}
 
 	// Ensure file ends with a newline
@@ -143,11 +246,42 @@ func writeFrontmatter(sb *strings.Builder, pg *page) {
 	if pg.Date != "" {
 		sb.WriteString(fmt.Sprintf("date: %s\n", yamlScalar(pg.Date)))
 	}
+	if pg.Updated != "" {
+		sb.WriteString(fmt.Sprintf("updated: %s\n", yamlScalar(pg.Updated)))
+	}
+	if pg.Draft {
+		sb.WriteString("draft: true\n")
+	}
 	sb.WriteString("---\n\n")
 }
 
-// generateJournalMarkdown generates markdown content for the journal page with entries
-func (b *builder) generateJournalMarkdown(pg *page) []byte {
+// writePagerLinks writes markdown links to the neighboring pages of a listing
+func writePagerLinks(sb *strings.Builder, p *pager) {
+	if p.Total <= 1 {
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf("\npage %d of %d", p.Number, p.Total))
+	if p.PrevURL != "" {
+		sb.WriteString(fmt.Sprintf(" · [newer](%s.md)", p.PrevURL))
+	}
+	if p.NextURL != "" {
+		sb.WriteString(fmt.Sprintf(" · [older](%s.md)", p.NextURL))
+	}
+	sb.WriteString("\n")
+}
+
+// writePostListItem writes a markdown list item linking to a blog post
+func (b *builder) writePostListItem(sb *strings.Builder, post blogPost) {
+	sb.WriteString(fmt.Sprintf("- %s [%s](/blog/%s)", b.displayDate(post.DateTime), post.Title, post.Slug))
+	if post.Draft {
+		sb.WriteString(" (draft)")
+	}
+	sb.WriteString("\n")
+}
+
+// generateJournalMarkdown generates markdown content for a page of the journal with entries
+func (b *builder) generateJournalMarkdown(pg *page, p *pager) []byte {
 	var sb strings.Builder
 
 	writeFrontmatter(&sb, pg)
@@ -156,15 +290,17 @@ func (b *builder) generateJournalMarkdown(pg *page) []byte {
 	sb.WriteString("# journal\n\n")
 
 	// Write journal entries as a list
-	for _, entry := range b.site.JournalEntries {
+	for _, entry := range p.JournalEntries {
 		sb.WriteString(fmt.Sprintf("- %s [%s](%s)\n", entry.Date, entry.URL, entry.URL))
 	}
 
+	writePagerLinks(&sb, p)
+
 	return []byte(sb.String())
 }
 
-// generateBlogIndexMarkdown generates markdown content for the blog index with posts
-func (b *builder) generateBlogIndexMarkdown(pg *page) []byte {
+// generateBlogIndexMarkdown generates markdown content for a page of the blog index with posts
+func (b *builder) generateBlogIndexMarkdown(pg *page, p *pager) []byte {
 	var sb strings.Builder
 
 	writeFrontmatter(&sb, pg)
@@ -173,8 +309,44 @@ func (b *builder) generateBlogIndexMarkdown(pg *page) []byte {
 	sb.WriteString("# blog\n\n")
 
 	// Write blog posts as a list
-	for _, post := range b.site.BlogPosts {
-		sb.WriteString(fmt.Sprintf("- %s [%s](/blog/%s)\n", post.Date, post.Title, post.Slug))
+	for _, post := range p.BlogPosts {
+		b.writePostListItem(&sb, post)
+	}
+
+	writePagerLinks(&sb, p)
+
+	return []byte(sb.String())
+}
+
+// generateTagIndexMarkdown generates markdown content for the tag index with post counts
+func (b *builder) generateTagIndexMarkdown(pg *page) []byte {
+	var sb strings.Builder
+
+	writeFrontmatter(&sb, pg)
+
+	// Write heading
+	sb.WriteString("# tags\n\n")
+
+	// Write tags as a list
+	for _, t := range b.site.Tags {
+		sb.WriteString(fmt.Sprintf("- [%s](%s) (%d)\n", t.Name, t.URL(), len(t.Posts)))
+	}
+
+	return []byte(sb.String())
+}
+
+// generateTagMarkdown generates markdown content for a tag's listing page with posts
+func (b *builder) generateTagMarkdown(pg *page, t *tag) []byte {
+	var sb strings.Builder
+
+	writeFrontmatter(&sb, pg)
+
+	// Write heading
+	sb.WriteString(fmt.Sprintf("# %s\n\n", t.Name))
+
+	// Write tagged posts as a list
+	for _, post := range t.Posts {
+		b.writePostListItem(&sb, post)
 	}
 
 	return []byte(sb.String())
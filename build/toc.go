@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Heading levels included in a table of contents unless a page overrides them
+const (
+	defaultTOCMinDepth = 2
+	defaultTOCMaxDepth = 3
+)
+
+// tocEntry is a heading in a page's table of contents
+type tocEntry struct {
+	Level    int
+	Text     string
+	ID       string
+	Children []*tocEntry
+}
+
+// buildTOC returns the headings under doc between the min and max levels,
+// nesting each heading under the closest preceding heading of a lower level
+func buildTOC(doc ast.Node, minLevel, maxLevel int) []*tocEntry {
+	var toc, stack []*tocEntry
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		h, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		if h.IsTitleblock || h.HeadingID == "" || h.Level < minLevel || h.Level > maxLevel {
+			return ast.SkipChildren
+		}
+
+		entry := &tocEntry{Level: h.Level, Text: plainText(h), ID: h.HeadingID}
+
+		for len(stack) > 0 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			toc = append(toc, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+
+		return ast.SkipChildren
+	})
+
+	return toc
+}
+
+// plainText returns the plain text under root, dropping inline markup
+func plainText(root ast.Node) string {
+	var sb strings.Builder
+	ast.WalkFunc(root, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Text:
+			sb.Write(n.Literal)
+		case *ast.Code:
+			sb.Write(n.Literal)
+		}
+		return ast.GoToNext
+	})
+	return strings.TrimSpace(sb.String())
+}
+
+// writeTOCMarkdown writes the table of contents as a nested markdown list of
+// links to the heading anchors
+func writeTOCMarkdown(sb *strings.Builder, toc []*tocEntry, depth int) {
+	for _, e := range toc {
+		text := strings.NewReplacer(`[`, `\[`, `]`, `\]`).Replace(e.Text)
+		sb.WriteString(fmt.Sprintf("%s- [%s](#%s)\n", strings.Repeat("  ", depth), text, e.ID))
+		writeTOCMarkdown(sb, e.Children, depth+1)
+	}
+}
+
+// markdownTwin returns the page's markdown for its .md twin: the original
+// frontmatter, its table of contents and the body with shortcodes rendered
+func markdownTwin(pg *page) []byte {
+	_, _, body, _ := splitFrontmatter(pg.MarkdownSource)
+	front := pg.MarkdownSource[:len(pg.MarkdownSource)-len(body)]
+
+	var sb strings.Builder
+	sb.Write(front)
+	if len(pg.TOC) > 0 {
+		if len(front) > 0 && !bytes.HasSuffix(front, []byte("\n")) {
+			sb.WriteString("\n")
+		}
+		writeTOCMarkdown(&sb, pg.TOC, 0)
+		sb.WriteString("\n")
+	}
+	sb.Write(pg.MarkdownBody)
+	return []byte(sb.String())
+}
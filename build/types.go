@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"time"
 )
@@ -12,10 +13,41 @@ type page struct {
 	Date           string
 	Content        template.HTML
 	MarkdownSource []byte // Raw markdown content with frontmatter
+	MarkdownBody   []byte // Markdown after the frontmatter with shortcodes rendered for the .md twin
 	URL            string
 	Slug           string
 	Template       string
 	Draft          bool
+	Tags           []string
+	Expires        string
+	Updated        string
+	Aliases        []string // old URLs that redirect to this page
+	Summary        string   // markdown summary from frontmatter
+	ShowTOC        bool
+	TOCMinDepth    int
+	TOCMaxDepth    int
+	TOC            []*tocEntry    // set when ShowTOC is enabled
+	WordCount      int            // words of prose, excluding code blocks
+	ReadingTime    int            // estimated minutes to read
+	Params         map[string]any // custom frontmatter keys, e.g. .Page.Params.hero
+
+	// Parsed dates, zero when unset
+	DateTime    time.Time
+	UpdatedTime time.Time
+	ExpiresTime time.Time
+}
+
+// LastModified returns when the page last changed in RFC 3339 format,
+// preferring its updated date over its publish date
+func (p *page) LastModified() string {
+	switch {
+	case !p.UpdatedTime.IsZero():
+		return formatDateAtom(p.UpdatedTime)
+	case !p.DateTime.IsZero():
+		return formatDateAtom(p.DateTime)
+	default:
+		return ""
+	}
 }
 
 // MarkdownURL returns the URL for the markdown version of this page
@@ -28,24 +60,28 @@ func (p *page) MarkdownURL() string {
 
 // siteData holds global site data
 type siteData struct {
+	Config         *siteConfig
 	JournalEntries []journal
 	BlogPosts      []blogPost
+	Tags           []tag
+	Pages          []*page // published pages for the sitemap, sorted by URL
 }
 
 // FeedJournalEntries returns the most recent entries for feeds
 func (s *siteData) FeedJournalEntries() []journal {
-	if len(s.JournalEntries) <= feedEntryLimit {
+	if len(s.JournalEntries) <= s.Config.Feeds.JournalLimit {
 		return s.JournalEntries
 	}
-	return s.JournalEntries[:feedEntryLimit]
+	return s.JournalEntries[:s.Config.Feeds.JournalLimit]
 }
 
-// FeedBlogPosts returns the most recent blog posts for feeds
+// FeedBlogPosts returns the most recent published blog posts for feeds
 func (s *siteData) FeedBlogPosts() []blogPost {
-	if len(s.BlogPosts) <= feedEntryLimit {
-		return s.BlogPosts
+	posts := publishedPosts(s.BlogPosts)
+	if len(posts) <= s.Config.Feeds.BlogLimit {
+		return posts
 	}
-	return s.BlogPosts[:feedEntryLimit]
+	return posts[:s.Config.Feeds.BlogLimit]
 }
 
 // LatestJournalDateAtom returns the most recent journal entry date in Atom format
@@ -56,23 +92,52 @@ func (s *siteData) LatestJournalDateAtom() string {
 	return s.JournalEntries[0].DateAtom
 }
 
-// LatestBlogDateAtom returns the most recent blog post date in Atom format
+// LatestBlogDateAtom returns the most recent published blog post date in Atom format
 func (s *siteData) LatestBlogDateAtom() string {
-	if len(s.BlogPosts) == 0 {
-		return time.Now().Format(time.RFC3339)
+	return latestPostDateAtom(s.BlogPosts)
+}
+
+// FeedTagPosts returns the most recent published blog posts with the given tag for feeds
+func (s *siteData) FeedTagPosts(t *tag) []blogPost {
+	posts := publishedPosts(t.Posts)
+	if len(posts) <= s.Config.Feeds.BlogLimit {
+		return posts
+	}
+	return posts[:s.Config.Feeds.BlogLimit]
+}
+
+// publishedPosts returns posts without drafts, which are only rendered for preview
+func publishedPosts(posts []blogPost) []blogPost {
+	published := make([]blogPost, 0, len(posts))
+	for _, p := range posts {
+		if !p.Draft {
+			published = append(published, p)
+		}
 	}
-	for _, p := range s.BlogPosts {
-		if p.DateAtom != "" {
-			return p.DateAtom
+	return published
+}
+
+// latestPostDateAtom returns the most recent publish or update time of the
+// published posts in Atom format
+func latestPostDateAtom(posts []blogPost) string {
+	var latest blogPost
+	for _, p := range posts {
+		if !p.Draft && p.lastModified().After(latest.lastModified()) {
+			latest = p
 		}
 	}
-	return time.Now().Format(time.RFC3339)
+	if latest.UpdatedAtom == "" {
+		return time.Now().Format(time.RFC3339)
+	}
+	return latest.UpdatedAtom
 }
 
 // templateData is passed to templates
 type templateData struct {
-	Page *page
-	Site *siteData
+	Page  *page
+	Site  *siteData
+	Tag   *tag   // set for tag listing pages and feeds
+	Pager *pager // set for paginated listing pages
 }
 
 // journal represents a journal entry
@@ -86,30 +151,102 @@ type journal struct {
 
 // blogPost represents a blog post
 type blogPost struct {
-	Title    string
-	Slug     string
-	Date     string
-	DateRSS  string
-	DateAtom string
-	Content  template.HTML
+	Title       string
+	Slug        string
+	Date        string
+	DateTime    time.Time
+	DateRSS     string
+	DateAtom    string
+	Updated     string
+	UpdatedTime time.Time
+	UpdatedAtom string // same as DateAtom when never updated
+	Content     template.HTML
+	Summary     template.HTML
+	WordCount   int
+	ReadingTime int // estimated minutes to read
+	Tags        []string
+	Draft       bool
+	Params      map[string]any
+}
+
+// lastModified returns the post's updated time, or its publish time if never updated
+func (p blogPost) lastModified() time.Time {
+	if !p.UpdatedTime.IsZero() {
+		return p.UpdatedTime
+	}
+	return p.DateTime
+}
+
+// tag is a taxonomy term and the blog posts labeled with it
+type tag struct {
+	Name  string
+	Slug  string
+	Posts []blogPost
+}
+
+// URL returns the URL of the tag's listing page
+func (t *tag) URL() string {
+	return "/" + pathTagsDir + "/" + t.Slug
+}
+
+// draft reports whether every post with the tag is a draft, so the tag only
+// exists in preview builds
+func (t *tag) draft() bool {
+	for _, post := range t.Posts {
+		if !post.Draft {
+			return false
+		}
+	}
+	return true
+}
+
+// LatestDateAtom returns the tag's most recent blog post date in Atom format
+func (t *tag) LatestDateAtom() string {
+	return latestPostDateAtom(t.Posts)
 }
 
 // frontmatter represents YAML frontmatter
 type frontmatter struct {
-	Title       string `yaml:"title"`
-	Description string `yaml:"description"`
-	Date        string `yaml:"date"`
-	Template    string `yaml:"template"`
-	Draft       bool   `yaml:"draft"`
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Date        string   `yaml:"date"`
+	Template    string   `yaml:"template"`
+	Draft       bool     `yaml:"draft"`
+	Tags        []string `yaml:"tags"`
+	Expires     string   `yaml:"expires"`
+	Updated     string   `yaml:"updated"`
+	Aliases     []string `yaml:"aliases"`
+	Summary     string   `yaml:"summary"`
+	TOC         bool     `yaml:"toc"`
+	TOCMinDepth int      `yaml:"toc_min_depth"`
+	TOCMaxDepth int      `yaml:"toc_max_depth"`
+	// Params collects every key not listed above
+	Params map[string]any `yaml:"-"`
 }
 
 // pageInfo holds page data and metadata for two-pass processing
 type pageInfo struct {
 	page         *page
-	path         string
+	path         string // source file, or the URL of generated pages
 	outputPath   string
 	templateName string
 	pathType     pathType
+	post         *blogPost // set for blog posts
+	tag          *tag      // set for tag listing pages
+	pager        *pager    // set for paginated listing pages
+	assets       []string  // files published next to a page bundle
+}
+
+// source describes where a page comes from for error messages
+func (info pageInfo) source() string {
+	switch {
+	case info.pathType == pathTag || info.pathType == pathTagIndex:
+		return "generated page " + info.path
+	case info.pager != nil && info.pager.Number > 1:
+		return fmt.Sprintf("page %d of %s", info.pager.Number, info.path)
+	default:
+		return info.path
+	}
 }
 
 // pathType represents the type of content path
@@ -121,4 +258,6 @@ const (
 	pathBlogIndex
 	pathBlogPost
 	pathPage
+	pathTagIndex
+	pathTag
 )
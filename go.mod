@@ -5,3 +5,5 @@ go 1.25.4
 require github.com/gomarkdown/markdown v0.0.0-20250810172220-2e2c11897d1a
 
 require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/BurntSushi/toml v1.6.0